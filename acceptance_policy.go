@@ -0,0 +1,96 @@
+package signalutils
+
+import (
+	"math"
+)
+
+//AcceptancePolicy decides if a new sample is consistent with the samples already in
+//a MovingAverage so that spurious samples (outliers) can be rejected.
+//Accept(..) is called while additions to 'm' are blocked, so it may read 'm' (Average(), StdDev(), ..),
+//but must not add samples to it (AddSample(..), AddSampleIfAccepted(..), ..), which would deadlock.
+//See MovingAverage.AddSampleIfAccepted(..)
+type AcceptancePolicy interface {
+	Accept(m *MovingAverage, value float64) bool
+}
+
+//RelativeBandPolicy accepts samples whose distance to the current average is at most 'Ratio' times the average absolute value
+//Similar to AddSampleIfNearAverage, but also works with negative averages. Not suitable for signals that oscillate around zero
+type RelativeBandPolicy struct {
+	Ratio float64
+}
+
+//nearAveragePolicy policy of AddSampleIfNearAverage, which rejects all samples on negative averages
+type nearAveragePolicy struct {
+	avgDiff float64
+}
+
+//AbsoluteBandPolicy accepts samples whose distance to the current average is at most 'Band'
+type AbsoluteBandPolicy struct {
+	Band float64
+}
+
+//StdDevBandPolicy accepts samples that are at most 'K' standard deviations away from the current average.
+//'MinStdDev' is the minimum standard deviation considered, so that a window with constant values doesn't reject everything else.
+//All samples are accepted while there are less than 'MinSamples' (and at least two) samples in window
+type StdDevBandPolicy struct {
+	K          float64
+	MinStdDev  float64
+	MinSamples int
+}
+
+//HampelPolicy Hampel filter. Accepts samples that are at most 'K' scaled MADs (median absolute deviation)
+//away from the median of current samples. Much more robust than StdDevBandPolicy as the median and MAD are not
+//influenced by outliers that were accepted. 'MinMAD' is the minimum scaled MAD considered, so that a window with
+//mostly constant values doesn't reject everything else. All samples are accepted while there are less than 'MinSamples'
+//samples in window. Evaluating this policy is O(n log n) on window size
+type HampelPolicy struct {
+	K          float64
+	MinMAD     float64
+	MinSamples int
+}
+
+//madScale scales the MAD so that it is a consistent estimator of the standard deviation for normal distributions
+const madScale = 1.4826
+
+//Accept checks if value is inside band
+func (p RelativeBandPolicy) Accept(m *MovingAverage, value float64) bool {
+	avg := m.Average()
+	return math.IsNaN(avg) || math.Abs(avg-value) <= math.Abs(avg)*p.Ratio
+}
+
+//Accept checks if value is inside band
+func (p nearAveragePolicy) Accept(m *MovingAverage, value float64) bool {
+	avg := m.Average()
+	return math.IsNaN(avg) || math.Abs(avg-value) <= avg*p.avgDiff
+}
+
+//Accept checks if value is inside band
+func (p AbsoluteBandPolicy) Accept(m *MovingAverage, value float64) bool {
+	avg := m.Average()
+	return math.IsNaN(avg) || math.Abs(avg-value) <= p.Band
+}
+
+//Accept checks if value is inside band
+func (p StdDevBandPolicy) Accept(m *MovingAverage, value float64) bool {
+	if m.Count() < p.MinSamples {
+		return true
+	}
+	avg := m.Average()
+	std := m.StdDev()
+	if math.IsNaN(avg) || math.IsNaN(std) {
+		return true
+	}
+	return math.Abs(avg-value) <= p.K*math.Max(std, p.MinStdDev)
+}
+
+//Accept checks if value is inside band
+func (p HampelPolicy) Accept(m *MovingAverage, value float64) bool {
+	if m.Count() < p.MinSamples {
+		return true
+	}
+	median, mad := m.MedianAbsoluteDeviation()
+	if math.IsNaN(median) {
+		return true
+	}
+	return math.Abs(median-value) <= p.K*math.Max(mad*madScale, p.MinMAD)
+}
@@ -0,0 +1,141 @@
+package signalutils
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelativeBandPolicyNegative(t *testing.T) {
+	ma := NewMovingAverage(5)
+	p := RelativeBandPolicy{Ratio: 0.5}
+	assert.True(t, ma.AddSampleIfAccepted(-1000, p))
+	assert.True(t, ma.AddSampleIfAccepted(-1200, p))
+	assert.False(t, ma.AddSampleIfAccepted(-5000, p))
+	assert.False(t, ma.AddSampleIfAccepted(1000, p))
+	assert.Equal(t, -1100.0, ma.Average())
+	assert.Equal(t, int64(2), ma.Rejected())
+}
+
+func TestAddSampleIfNearAverageNegative(t *testing.T) {
+	//unlike RelativeBandPolicy, all samples are rejected while the average is negative
+	ma := NewMovingAverage(5)
+	assert.True(t, ma.AddSampleIfNearAverage(-1000, 0.5))
+	assert.False(t, ma.AddSampleIfNearAverage(-1000, 0.5))
+	assert.False(t, ma.AddSampleIfNearAverage(-1200, 0.5))
+	assert.Equal(t, -1000.0, ma.Average())
+	assert.Equal(t, int64(2), ma.Rejected())
+}
+
+//maxCountPolicy accepts samples while there are less than 'max' samples
+type maxCountPolicy struct {
+	max int
+}
+
+func (p maxCountPolicy) Accept(m *MovingAverage, value float64) bool {
+	return m.Count() < p.max
+}
+
+func TestAddSampleIfAcceptedConcurrent(t *testing.T) {
+	ma := NewMovingAverage(100)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ma.AddSampleIfAccepted(1, maxCountPolicy{10})
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, ma.Count())
+	assert.Equal(t, int64(40), ma.Rejected())
+}
+
+func TestAbsoluteBandPolicy(t *testing.T) {
+	ma := NewMovingAverage(5)
+	p := AbsoluteBandPolicy{Band: 10}
+	assert.True(t, ma.AddSampleIfAccepted(0, p))
+	assert.True(t, ma.AddSampleIfAccepted(5, p))
+	assert.True(t, ma.AddSampleIfAccepted(-7, p))
+	assert.False(t, ma.AddSampleIfAccepted(11, p))
+	assert.Equal(t, int64(1), ma.Rejected())
+}
+
+func TestStdDevBandPolicy(t *testing.T) {
+	ma := NewMovingAverage(100)
+	p := StdDevBandPolicy{K: 3, MinStdDev: 0.1, MinSamples: 2}
+	for i := 0; i < 100; i++ {
+		assert.True(t, ma.AddSampleIfAccepted(float64(i%2), p))
+	}
+	assert.True(t, ma.AddSampleIfAccepted(1.5, p))
+	assert.False(t, ma.AddSampleIfAccepted(3, p))
+	assert.False(t, ma.AddSampleIfAccepted(-2, p))
+	assert.Equal(t, int64(2), ma.Rejected())
+
+	//constant window doesn't reject everything
+	ma2 := NewMovingAverage(10)
+	for i := 0; i < 10; i++ {
+		ma2.AddSample(0)
+	}
+	assert.True(t, ma2.AddSampleIfAccepted(0.2, p))
+	assert.False(t, ma2.AddSampleIfAccepted(1, p))
+}
+
+func TestHampelPolicy(t *testing.T) {
+	ma := NewMovingAverage(9)
+	for _, v := range []float64{10, 11, 9, 10, 12, 8, 10, 11, 9} {
+		ma.AddSample(v)
+	}
+	median, mad := ma.MedianAbsoluteDeviation()
+	assert.Equal(t, 10.0, median)
+	assert.Equal(t, 1.0, mad)
+	assert.Equal(t, 10.0, ma.Median())
+
+	p := HampelPolicy{K: 3, MinMAD: 0.01}
+	assert.True(t, ma.AddSampleIfAccepted(14, p))
+	assert.False(t, ma.AddSampleIfAccepted(15, p))
+	assert.False(t, ma.AddSampleIfAccepted(-1000, p))
+	assert.Equal(t, int64(2), ma.Rejected())
+}
+
+func TestHampelPolicyOutliersDontDrift(t *testing.T) {
+	ma := NewMovingAverage(50)
+	p := HampelPolicy{K: 4, MinMAD: 0.01, MinSamples: 5}
+	for i := 0; i < 1000; i++ {
+		v := rand.NormFloat64()
+		if i%10 == 9 {
+			v = 100
+		}
+		ma.AddSampleIfAccepted(v, p)
+	}
+	assert.InDelta(t, 0.0, ma.Average(), 0.5)
+	assert.GreaterOrEqual(t, ma.Rejected(), int64(100))
+	assert.Less(t, ma.Rejected(), int64(110))
+}
+
+func TestDynamicSchmittTriggerAcceptancePolicy(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	dst, _ := NewDynamicSchmittTriggerTimeWindowWithClock(5*time.Second, 200, 10, 0.1, 0.5, false, c)
+	dst.SetAcceptancePolicy(HampelPolicy{K: 5, MinMAD: 5, MinSamples: 10})
+
+	//signal around zero would be rejected by the relative band
+	for i := -150; i < 150; i++ {
+		v := float64(i + rand.Intn(10))
+		if i%50 == 25 || i%50 == -25 {
+			v = 100000
+		}
+		dst.SetCurrentValue(v)
+		c.Advance(30 * time.Millisecond)
+	}
+	assert.True(t, dst.IsUpperRange())
+	assert.Equal(t, int64(6), dst.RejectedSamples())
+
+	for i := 150; i > -150; i-- {
+		dst.SetCurrentValue(float64(i + rand.Intn(10)))
+		c.Advance(30 * time.Millisecond)
+	}
+	assert.False(t, dst.IsUpperRange())
+}
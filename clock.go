@@ -0,0 +1,233 @@
+package signalutils
+
+import (
+	"sync"
+	"time"
+)
+
+//Clock source of time used by all time based utilities
+//Use SystemClock for real time or a ManualClock in tests so that time can be
+//advanced deterministically without sleeping
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+	Sleep(d time.Duration)
+}
+
+//Ticker ticker created by a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+//Timer single shot timer created by a Clock. Unlike After(..), it can be stopped so that
+//its resources are released before it fires
+type Timer interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+//SystemClock clock backed by the real wall clock (package time)
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return &systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type systemTicker struct {
+	t *time.Ticker
+}
+
+func (s *systemTicker) C() <-chan time.Time {
+	return s.t.C
+}
+
+func (s *systemTicker) Stop() {
+	s.t.Stop()
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (s *systemTimer) C() <-chan time.Time {
+	return s.t.C
+}
+
+func (s *systemTimer) Stop() {
+	s.t.Stop()
+}
+
+//ManualClock fake clock whose time only changes when Advance(..) or Set(..) is called
+//Tickers, timers and sleepers created from it are fired when the clock reaches their deadline
+//Only initialize this with NewManualClock(..)
+type ManualClock struct {
+	now     time.Time
+	waiters []*manualWaiter
+	m       *sync.Mutex
+	cond    *sync.Cond
+}
+
+type manualWaiter struct {
+	deadline time.Time
+	period   time.Duration
+	c        chan time.Time
+	clock    *ManualClock
+}
+
+//NewManualClock creates a new fake clock starting at 'start'
+func NewManualClock(start time.Time) *ManualClock {
+	m := &sync.Mutex{}
+	return &ManualClock{
+		now:     start,
+		waiters: make([]*manualWaiter, 0),
+		m:       m,
+		cond:    sync.NewCond(m),
+	}
+}
+
+//Now returns the current fake time
+func (c *ManualClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+//NewTicker creates a ticker that ticks each time the clock is advanced past 'd' since the last tick
+//Just like time.Ticker, ticks are dropped if the receiver is not keeping up
+func (c *ManualClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for ManualClock.NewTicker")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	w := &manualWaiter{
+		deadline: c.now.Add(d),
+		period:   d,
+		c:        make(chan time.Time, 1),
+		clock:    c,
+	}
+	c.addWaiter(w)
+	return w
+}
+
+//After returns a channel that receives the current fake time once the clock is advanced by 'd'
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+//NewTimer creates a timer that fires once the clock is advanced by 'd'
+//Stop() removes it from the clock, so that it isn't counted by BlockUntil(..) anymore
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	c.m.Lock()
+	defer c.m.Unlock()
+	w := &manualWaiter{
+		deadline: c.now.Add(d),
+		c:        make(chan time.Time, 1),
+		clock:    c,
+	}
+	if d <= 0 {
+		w.c <- c.now
+		return w
+	}
+	c.addWaiter(w)
+	return w
+}
+
+//Sleep blocks until the clock is advanced by 'd'
+func (c *ManualClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+//Advance moves the clock forward by 'd', firing all tickers and sleepers whose deadline was reached
+func (c *ManualClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.setTime(c.now.Add(d))
+}
+
+//Set moves the clock to time 't'. Setting a time in the past doesn't fire anything
+func (c *ManualClock) Set(t time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.setTime(t)
+}
+
+//BlockUntil waits until at least 'n' tickers or sleepers are waiting on this clock
+//Useful in tests to make sure a goroutine reached its Sleep(..) before advancing the clock
+func (c *ManualClock) BlockUntil(n int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *ManualClock) addWaiter(w *manualWaiter) {
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+func (c *ManualClock) setTime(t time.Time) {
+	c.now = t
+	remaining := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(t) {
+			remaining = append(remaining, w)
+			continue
+		}
+		//non blocking send. drop tick if receiver is slow
+		select {
+		case w.c <- t:
+		default:
+		}
+		if w.period > 0 {
+			for !w.deadline.After(t) {
+				w.deadline = w.deadline.Add(w.period)
+			}
+			remaining = append(remaining, w)
+		}
+	}
+	for i := len(remaining); i < len(c.waiters); i++ {
+		c.waiters[i] = nil
+	}
+	c.waiters = remaining
+}
+
+//C channel in which ticks are delivered
+func (w *manualWaiter) C() <-chan time.Time {
+	return w.c
+}
+
+//Stop turns off the ticker or timer. No more ticks will be sent
+func (w *manualWaiter) Stop() {
+	c := w.clock
+	c.m.Lock()
+	defer c.m.Unlock()
+	for i, w2 := range c.waiters {
+		if w2 == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package signalutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManualClockNow(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+	assert.Equal(t, start, c.Now())
+	c.Advance(1500 * time.Millisecond)
+	assert.Equal(t, start.Add(1500*time.Millisecond), c.Now())
+	c.Set(start)
+	assert.Equal(t, start, c.Now())
+}
+
+func TestManualClockAfter(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ch := c.After(1 * time.Second)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-ch:
+		assert.Fail(t, "fired too early")
+	default:
+	}
+	c.Advance(1 * time.Millisecond)
+	select {
+	case v := <-ch:
+		assert.Equal(t, c.Now(), v)
+	default:
+		assert.Fail(t, "should have fired")
+	}
+}
+
+func TestManualClockTimerStop(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	timer := c.NewTimer(1 * time.Second)
+	c.BlockUntil(1)
+	timer.Stop()
+	assert.Equal(t, 0, len(c.waiters))
+	c.Advance(1 * time.Second)
+	select {
+	case <-timer.C():
+		assert.Fail(t, "stopped timer fired")
+	default:
+	}
+}
+
+func TestManualClockSleep(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	done := make(chan bool)
+	go func() {
+		c.Sleep(10 * time.Second)
+		done <- true
+	}()
+	c.BlockUntil(1)
+	c.Advance(5 * time.Second)
+	select {
+	case <-done:
+		assert.Fail(t, "woke up too early")
+	default:
+	}
+	c.Advance(5 * time.Second)
+	assert.True(t, <-done)
+}
+
+func TestManualClockTicker(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tk := c.NewTicker(100 * time.Millisecond)
+	ticks := 0
+	for i := 0; i < 10; i++ {
+		c.Advance(50 * time.Millisecond)
+		select {
+		case <-tk.C():
+			ticks = ticks + 1
+		default:
+		}
+	}
+	assert.Equal(t, 5, ticks)
+
+	//slow receiver drops ticks
+	c.Advance(1 * time.Second)
+	<-tk.C()
+	select {
+	case <-tk.C():
+		assert.Fail(t, "ticks should have been dropped")
+	default:
+	}
+
+	tk.Stop()
+	c.Advance(1 * time.Second)
+	select {
+	case <-tk.C():
+		assert.Fail(t, "stopped ticker should not tick")
+	default:
+	}
+}
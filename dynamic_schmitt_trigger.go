@@ -1,6 +1,7 @@
 package signalutils
 
 import (
+	"math"
 	"sync"
 	"time"
 )
@@ -13,6 +14,8 @@ type DynamicSchmittTrigger struct {
 	ignoreSamplesTooDifferentRatio float64
 	groupByMinMaxSamples           int
 	minMaxUpperLowerRatio          float64
+	filter                         Averager
+	acceptancePolicy               AcceptancePolicy
 	m                              *sync.Mutex
 }
 
@@ -23,7 +26,12 @@ type DynamicSchmittTrigger struct {
 //ignoreSamplesTooHighRatio - if SetCurrentValue sets a value that is too high or too low according to min/max moving average, ignore it
 //minMaxUpperLowerRatio - 1.0 indicates the lower and upper limits will be placed just like the min/max moving average, which is not too practical. A number between 0.3 and 0.7 is good here.
 func NewDynamicSchmittTriggerTimeWindow(minMaxMovingAverageTime time.Duration, maxMovingAverageSamples int, groupByMinMaxSamples int, ignoreSamplesTooDifferentRatio float64, minMaxUpperLowerRatio float64, upperRange bool) (DynamicSchmittTrigger, error) {
-	minMaxMovAvg := NewMovingAverageTimeWindow(minMaxMovingAverageTime, maxMovingAverageSamples)
+	return NewDynamicSchmittTriggerTimeWindowWithClock(minMaxMovingAverageTime, maxMovingAverageSamples, groupByMinMaxSamples, ignoreSamplesTooDifferentRatio, minMaxUpperLowerRatio, upperRange, SystemClock)
+}
+
+//NewDynamicSchmittTriggerTimeWindowWithClock same as NewDynamicSchmittTriggerTimeWindow(..), but using 'clock' as time source for the min/max moving average
+func NewDynamicSchmittTriggerTimeWindowWithClock(minMaxMovingAverageTime time.Duration, maxMovingAverageSamples int, groupByMinMaxSamples int, ignoreSamplesTooDifferentRatio float64, minMaxUpperLowerRatio float64, upperRange bool, clock Clock) (DynamicSchmittTrigger, error) {
+	minMaxMovAvg := NewMovingAverageTimeWindowWithClock(minMaxMovingAverageTime, maxMovingAverageSamples, clock)
 	schmittTrigger, _ := NewSchmittTrigger(0, 0.1, upperRange)
 	return DynamicSchmittTrigger{
 		minMaxMovAvg:                   minMaxMovAvg,
@@ -38,11 +46,24 @@ func NewDynamicSchmittTriggerTimeWindow(minMaxMovingAverageTime time.Duration, m
 //SetCurrentValue set current value and calculate if it is in upper or lower range
 //returns 1-true or false if value was accepted by internal moving averager (rate not too high)
 //        2-how much the current value is distant from the lower limit (if it is in 'upperRange' state) or distant from the upper limit (if in 'lowerRange' state) for a new change to occur in trigger. a ratio in relation to max-min range will be returned
+//If an input filter is set, the filtered value is used instead
 func (s *DynamicSchmittTrigger) SetCurrentValue(value float64) (bool, float64) {
 	s.m.Lock()
 	defer s.m.Unlock()
+	if s.filter != nil {
+		s.filter.AddSample(value)
+		value = s.filter.Average()
+		if math.IsNaN(value) {
+			return false, math.NaN()
+		}
+	}
 
-	b := s.minMaxMovAvg.AddSampleIfNearAverage(value, s.ignoreSamplesTooDifferentRatio)
+	var b bool
+	if s.acceptancePolicy != nil {
+		b = s.minMaxMovAvg.AddSampleIfAccepted(value, s.acceptancePolicy)
+	} else {
+		b = s.minMaxMovAvg.AddSampleIfNearAverage(value, s.ignoreSamplesTooDifferentRatio)
+	}
 	min, max := s.minMaxMovAvg.AverageMinMax(s.groupByMinMaxSamples)
 	cw := max - min/2
 	min2 := min + (cw-min)*(1-s.minMaxUpperLowerRatio)
@@ -56,6 +77,27 @@ func (s *DynamicSchmittTrigger) SetCurrentValue(value float64) (bool, float64) {
 	return b, (value - s.schmittTrigger.UpperLimit) // / (max - min)
 }
 
+//SetInputFilter sets a filter (MedianFilter, TrimmedMean, MovingAverage etc) that will be used to
+//smooth values before they are evaluated by the min/max moving average and the trigger. nil disables filtering
+func (s *DynamicSchmittTrigger) SetInputFilter(filter Averager) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.filter = filter
+}
+
+//SetAcceptancePolicy sets the policy used to ignore samples too different from the ones in the min/max moving average
+//(StdDevBandPolicy, HampelPolicy etc), replacing the relative band defined by 'ignoreSamplesTooDifferentRatio'. nil restores the relative band
+func (s *DynamicSchmittTrigger) SetAcceptancePolicy(policy AcceptancePolicy) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.acceptancePolicy = policy
+}
+
+//RejectedSamples number of samples ignored because they were too different from the ones in the min/max moving average
+func (s *DynamicSchmittTrigger) RejectedSamples() int64 {
+	return s.minMaxMovAvg.Rejected()
+}
+
 //IsUpperRange returns if this trigger is in upper or low range
 func (s *DynamicSchmittTrigger) IsUpperRange() bool {
 	return s.schmittTrigger.IsUpperRange()
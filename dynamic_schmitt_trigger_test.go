@@ -45,3 +45,31 @@ func TestDynamicSchmittTrigger1(t *testing.T) {
 	assert.False(t, dst.IsUpperRange())
 
 }
+
+func TestDynamicSchmittTriggerInputFilter(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	dst, err := NewDynamicSchmittTriggerTimeWindowWithClock(5*time.Second, 200, 10, 5.0, 0.5, false, c)
+	assert.Nil(t, err)
+	mf, _ := NewMedianFilter(5)
+	dst.SetInputFilter(&mf)
+
+	for i := 200; i < 500; i++ {
+		v := float64(i + rand.Intn(10))
+		if i%50 == 0 {
+			v = 0 //spike
+		}
+		dst.SetCurrentValue(v)
+		c.Advance(30 * time.Millisecond)
+	}
+	assert.True(t, dst.IsUpperRange())
+
+	for i := 500; i > 200; i-- {
+		v := float64(i + rand.Intn(10))
+		if i%50 == 0 {
+			v = 10000 //spike
+		}
+		dst.SetCurrentValue(v)
+		c.Advance(30 * time.Millisecond)
+	}
+	assert.False(t, dst.IsUpperRange())
+}
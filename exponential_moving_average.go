@@ -0,0 +1,111 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+//ExponentialMovingAverage exponentially weighted moving averager
+//In regular mode each new sample has weight 'Alpha' and the previous average has weight 1-Alpha.
+//In half life mode the weight of past samples decays according to the time elapsed between samples,
+//so that it can be used with samples taken in irregular intervals
+//Only initialize this with NewExponentialMovingAverage(..) or NewExponentialMovingAverageHalfLife(..)
+type ExponentialMovingAverage struct {
+	Alpha          float64
+	HalfLife       time.Duration
+	value          float64
+	weight         float64
+	lastSampleTime time.Time
+	clock          Clock
+	m              *sync.Mutex
+}
+
+//NewExponentialMovingAverage creates a new exponential moving averager in which each new
+//sample is added with weight 'alpha' (0 < alpha <= 1). Higher alpha discounts older samples faster
+func NewExponentialMovingAverage(alpha float64) (ExponentialMovingAverage, error) {
+	if alpha <= 0 || alpha > 1 {
+		return ExponentialMovingAverage{}, fmt.Errorf("alpha must be in range (0, 1]")
+	}
+	return ExponentialMovingAverage{
+		Alpha: alpha,
+		value: math.NaN(),
+		clock: SystemClock,
+		m:     &sync.Mutex{},
+	}, nil
+}
+
+//NewExponentialMovingAverageHalfLife creates a new exponential moving averager in which
+//the weight of a sample is halved after each 'halfLife' period of time
+func NewExponentialMovingAverageHalfLife(halfLife time.Duration) (ExponentialMovingAverage, error) {
+	return NewExponentialMovingAverageHalfLifeWithClock(halfLife, SystemClock)
+}
+
+//NewExponentialMovingAverageHalfLifeWithClock same as NewExponentialMovingAverageHalfLife(..), but using 'clock' as time source
+func NewExponentialMovingAverageHalfLifeWithClock(halfLife time.Duration, clock Clock) (ExponentialMovingAverage, error) {
+	if halfLife <= 0 {
+		return ExponentialMovingAverage{}, fmt.Errorf("halfLife must be positive")
+	}
+	return ExponentialMovingAverage{
+		HalfLife: halfLife,
+		value:    math.NaN(),
+		clock:    clock,
+		m:        &sync.Mutex{},
+	}, nil
+}
+
+//AddSample adds a new sample to the average using current clock time
+//Always returns true (all samples are accepted)
+func (e *ExponentialMovingAverage) AddSample(value float64) bool {
+	return e.AddSampleWithTime(value, e.clock.Now())
+}
+
+//AddSampleWithTime adds a new sample that was taken at time 'when'
+//Time is only relevant in half life mode. Samples older than the last sample are decayed as if they happened together with the last sample
+func (e *ExponentialMovingAverage) AddSampleWithTime(value float64, when time.Time) bool {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.HalfLife <= 0 {
+		if math.IsNaN(e.value) {
+			e.value = value
+		} else {
+			e.value = e.Alpha*value + (1-e.Alpha)*e.value
+		}
+		return true
+	}
+
+	//value holds the decayed weighted sum and weight the decayed sum of weights
+	decay := 1.0
+	if !e.lastSampleTime.IsZero() && when.After(e.lastSampleTime) {
+		decay = math.Exp2(-float64(when.Sub(e.lastSampleTime)) / float64(e.HalfLife))
+	}
+	if math.IsNaN(e.value) {
+		e.value = 0
+	}
+	e.value = e.value*decay + value
+	e.weight = e.weight*decay + 1
+	if when.After(e.lastSampleTime) {
+		e.lastSampleTime = when
+	}
+	return true
+}
+
+//Average returns the current exponential moving average. NaN if no samples were added
+func (e *ExponentialMovingAverage) Average() float64 {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if e.HalfLife <= 0 || math.IsNaN(e.value) {
+		return e.value
+	}
+	return e.value / e.weight
+}
+
+//Reset forget all samples
+func (e *ExponentialMovingAverage) Reset() {
+	e.m.Lock()
+	defer e.m.Unlock()
+	e.value = math.NaN()
+	e.weight = 0
+	e.lastSampleTime = time.Time{}
+}
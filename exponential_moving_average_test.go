@@ -0,0 +1,79 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEMA1(t *testing.T) {
+	_, err := NewExponentialMovingAverage(0)
+	assert.NotNil(t, err)
+	_, err = NewExponentialMovingAverage(1.1)
+	assert.NotNil(t, err)
+
+	ema, err := NewExponentialMovingAverage(0.5)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(ema.Average()))
+	ema.AddSample(100)
+	assert.Equal(t, 100.0, ema.Average())
+	ema.AddSample(200)
+	assert.Equal(t, 150.0, ema.Average())
+	ema.AddSample(50)
+	assert.Equal(t, 100.0, ema.Average())
+
+	ema.Reset()
+	assert.True(t, math.IsNaN(ema.Average()))
+	ema.AddSample(10)
+	assert.Equal(t, 10.0, ema.Average())
+}
+
+func TestEMAConverges(t *testing.T) {
+	ema, _ := NewExponentialMovingAverage(0.1)
+	ema.AddSample(0)
+	for i := 0; i < 200; i++ {
+		ema.AddSample(1000)
+	}
+	assert.InDelta(t, 1000.0, ema.Average(), 0.01)
+}
+
+func TestEMAHalfLife(t *testing.T) {
+	_, err := NewExponentialMovingAverageHalfLife(0)
+	assert.NotNil(t, err)
+
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ema, err := NewExponentialMovingAverageHalfLifeWithClock(1*time.Second, c)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(ema.Average()))
+
+	ema.AddSample(100)
+	assert.Equal(t, 100.0, ema.Average())
+
+	//samples at the same time have the same weight
+	ema.AddSample(200)
+	assert.Equal(t, 150.0, ema.Average())
+
+	//after one half life older samples are worth half
+	c.Advance(1 * time.Second)
+	ema.AddSample(0)
+	assert.InDelta(t, 75.0, ema.Average(), 1e-9)
+
+	//irregular intervals. long gap makes past samples irrelevant
+	c.Advance(60 * time.Second)
+	ema.AddSample(500)
+	assert.InDelta(t, 500.0, ema.Average(), 1e-6)
+}
+
+func TestEMAAsAverager(t *testing.T) {
+	ema, _ := NewExponentialMovingAverage(1)
+	ma := NewMovingAverage(1)
+	for _, a := range []Averager{&ema, &ma} {
+		a.AddSample(10)
+		a.AddSample(20)
+		assert.Equal(t, 20.0, a.Average())
+		a.Reset()
+		assert.True(t, math.IsNaN(a.Average()))
+	}
+}
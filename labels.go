@@ -0,0 +1,140 @@
+package signalutils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//MetricNameLabel label with the metric name of a series, just like in Prometheus
+const MetricNameLabel = "__name__"
+
+//Labels label names and values that identify a series. Empty values are the same as missing labels
+type Labels map[string]string
+
+//copy returns a copy of these labels without empty values
+func (l Labels) copy() Labels {
+	c := Labels{}
+	for k, v := range l {
+		if v != "" {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+//names returns the names of non empty labels, sorted
+func (l Labels) names() []string {
+	ns := make([]string, 0, len(l))
+	for k, v := range l {
+		if v != "" {
+			ns = append(ns, k)
+		}
+	}
+	sort.Strings(ns)
+	return ns
+}
+
+//key unique identification of this label set
+func (l Labels) key() string {
+	b := strings.Builder{}
+	for _, k := range l.names() {
+		b.WriteString(strconv.Quote(k))
+		b.WriteString(strconv.Quote(l[k]))
+	}
+	return b.String()
+}
+
+//String labels in Prometheus format, as in 'name{label1="value1", label2="value2"}'
+func (l Labels) String() string {
+	pairs := make([]string, 0, len(l))
+	for _, k := range l.names() {
+		if k == MetricNameLabel {
+			continue
+		}
+		pairs = append(pairs, k+"="+strconv.Quote(l[k]))
+	}
+	return l[MetricNameLabel] + "{" + strings.Join(pairs, ", ") + "}"
+}
+
+//MatchType how a LabelMatcher compares label values
+type MatchType int
+
+const (
+	//MatchEqual label value must be equal to the matcher value (=)
+	MatchEqual MatchType = iota
+	//MatchNotEqual label value must be different from the matcher value (!=)
+	MatchNotEqual
+	//MatchRegexp whole label value must match the matcher regular expression (=~)
+	MatchRegexp
+	//MatchNotRegexp whole label value must not match the matcher regular expression (!~)
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return fmt.Sprintf("MatchType(%d)", int(t))
+}
+
+//LabelMatcher selects series by the value of one label. Missing labels have the empty value,
+//so {name!="x"} matches series without the label 'name' too
+//Only initialize this with NewLabelMatcher(..)
+type LabelMatcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+//NewLabelMatcher creates a new label matcher. For regexp matchers 'value' is a regular expression
+//which must match the whole label value, as in Prometheus
+func NewLabelMatcher(matchType MatchType, name string, value string) (LabelMatcher, error) {
+	m := LabelMatcher{
+		Type:  matchType,
+		Name:  name,
+		Value: value,
+	}
+	switch matchType {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return LabelMatcher{}, err
+		}
+		m.re = re
+	default:
+		return LabelMatcher{}, fmt.Errorf("unknown match type %d", matchType)
+	}
+	return m, nil
+}
+
+//Matches checks if 'labels' are selected by this matcher
+func (m LabelMatcher) Matches(labels Labels) bool {
+	v := labels[m.Name]
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m LabelMatcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
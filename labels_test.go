@@ -0,0 +1,52 @@
+package signalutils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabels(t *testing.T) {
+	l := Labels{MetricNameLabel: "http_requests", "method": "GET", "code": "200", "empty": ""}
+	assert.Equal(t, `http_requests{code="200", method="GET"}`, l.String())
+	assert.Equal(t, `{a="x\"y"}`, Labels{"a": `x"y`}.String())
+	assert.Equal(t, l.key(), Labels{MetricNameLabel: "http_requests", "code": "200", "method": "GET"}.key())
+	assert.NotEqual(t, Labels{"a": "b,c"}.key(), Labels{"a": "b", "c": ""}.key())
+	assert.NotEqual(t, Labels{"ab": "c"}.key(), Labels{"a": "bc"}.key())
+	c := l.copy()
+	assert.Equal(t, 3, len(c))
+	c["code"] = "500"
+	assert.Equal(t, "200", l["code"])
+}
+
+func TestLabelMatcher(t *testing.T) {
+	l := Labels{"method": "GET", "code": "200"}
+
+	m, err := NewLabelMatcher(MatchEqual, "method", "GET")
+	assert.Nil(t, err)
+	assert.True(t, m.Matches(l))
+	m, _ = NewLabelMatcher(MatchNotEqual, "method", "GET")
+	assert.False(t, m.Matches(l))
+	//missing labels are empty
+	m, _ = NewLabelMatcher(MatchNotEqual, "path", "/")
+	assert.True(t, m.Matches(l))
+	m, _ = NewLabelMatcher(MatchEqual, "path", "")
+	assert.True(t, m.Matches(l))
+
+	//regexps are anchored
+	m, err = NewLabelMatcher(MatchRegexp, "code", "2..")
+	assert.Nil(t, err)
+	assert.True(t, m.Matches(l))
+	m, _ = NewLabelMatcher(MatchRegexp, "code", "2")
+	assert.False(t, m.Matches(l))
+	m, _ = NewLabelMatcher(MatchRegexp, "code", "5..|2..")
+	assert.True(t, m.Matches(l))
+	m, _ = NewLabelMatcher(MatchNotRegexp, "code", "5..")
+	assert.True(t, m.Matches(l))
+	assert.Equal(t, `code!~"5.."`, m.String())
+
+	_, err = NewLabelMatcher(MatchRegexp, "code", "(")
+	assert.NotNil(t, err)
+	_, err = NewLabelMatcher(MatchType(9), "code", "")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,167 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+//MedianFilter sliding window median filter. Useful for rejecting spikes (impulsive noise)
+//without the lag and distortion caused by averaging them. Implements Averager, in which
+//Average() returns the median, so it can be used as the input stage of SchmittTrigger and DynamicSchmittTrigger
+//Only initialize this with NewMedianFilter(..)
+type MedianFilter struct {
+	window sortedWindow
+	m      *sync.Mutex
+}
+
+//TrimmedMean sliding window mean in which the highest and lowest samples are discarded
+//before averaging. Implements Averager
+//Only initialize this with NewTrimmedMean(..)
+type TrimmedMean struct {
+	TrimRatio float64
+	window    sortedWindow
+	m         *sync.Mutex
+}
+
+//NewMedianFilter creates a new median filter over the last 'size' samples
+func NewMedianFilter(size int) (MedianFilter, error) {
+	if size < 1 {
+		return MedianFilter{}, fmt.Errorf("size must be at least 1")
+	}
+	return MedianFilter{
+		window: newSortedWindow(size),
+		m:      &sync.Mutex{},
+	}, nil
+}
+
+//AddSample adds a new sample to the window. If there is more than 'size' samples, the oldest sample will be removed.
+//NaN values are ignored (returns false)
+func (f *MedianFilter) AddSample(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.window.push(value)
+	return true
+}
+
+//Median returns the median of current samples. NaN if there are no samples
+func (f *MedianFilter) Median() float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.window.median()
+}
+
+//Average same as Median(). Used for compatibility with Averager
+func (f *MedianFilter) Average() float64 {
+	return f.Median()
+}
+
+//Reset internal samples
+func (f *MedianFilter) Reset() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.window.reset()
+}
+
+//NewTrimmedMean creates a new trimmed mean over the last 'size' samples.
+//'trimRatio' is the fraction of samples discarded in each side (0.1 discards the 10% lowest and 10% highest samples)
+func NewTrimmedMean(size int, trimRatio float64) (TrimmedMean, error) {
+	if size < 1 {
+		return TrimmedMean{}, fmt.Errorf("size must be at least 1")
+	}
+	if trimRatio < 0 || trimRatio >= 0.5 {
+		return TrimmedMean{}, fmt.Errorf("trimRatio must be in range [0, 0.5)")
+	}
+	return TrimmedMean{
+		TrimRatio: trimRatio,
+		window:    newSortedWindow(size),
+		m:         &sync.Mutex{},
+	}, nil
+}
+
+//AddSample adds a new sample to the window. If there is more than 'size' samples, the oldest sample will be removed.
+//NaN values are ignored (returns false)
+func (f *TrimmedMean) AddSample(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.window.push(value)
+	return true
+}
+
+//Average returns the mean of current samples after discarding the 'trimRatio' lowest and highest samples
+//NaN if there are no samples
+func (f *TrimmedMean) Average() float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	n := len(f.window.sorted)
+	if n == 0 {
+		return math.NaN()
+	}
+	k := int(float64(n) * f.TrimRatio)
+	sum := 0.0
+	for _, v := range f.window.sorted[k : n-k] {
+		sum = sum + v
+	}
+	return sum / float64(n-2*k)
+}
+
+//Reset internal samples
+func (f *TrimmedMean) Reset() {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.window.reset()
+}
+
+//sortedWindow fixed size window of samples that are also kept sorted by value
+type sortedWindow struct {
+	samples []float64
+	head    int
+	sorted  []float64
+}
+
+func newSortedWindow(size int) sortedWindow {
+	return sortedWindow{
+		samples: make([]float64, size),
+		sorted:  make([]float64, 0, size),
+	}
+}
+
+func (w *sortedWindow) push(value float64) {
+	size := len(w.sorted)
+	if size == len(w.samples) {
+		//remove oldest
+		old := w.samples[w.head]
+		i := sort.SearchFloat64s(w.sorted, old)
+		w.sorted = append(w.sorted[:i], w.sorted[i+1:]...)
+		w.head = (w.head + 1) % len(w.samples)
+		size = size - 1
+	}
+	w.samples[(w.head+size)%len(w.samples)] = value
+	i := sort.SearchFloat64s(w.sorted, value)
+	w.sorted = append(w.sorted, 0)
+	copy(w.sorted[i+1:], w.sorted[i:])
+	w.sorted[i] = value
+}
+
+func (w *sortedWindow) median() float64 {
+	n := len(w.sorted)
+	if n == 0 {
+		return math.NaN()
+	}
+	if n%2 == 1 {
+		return w.sorted[n/2]
+	}
+	return (w.sorted[n/2-1] + w.sorted[n/2]) / 2
+}
+
+func (w *sortedWindow) reset() {
+	w.sorted = w.sorted[:0]
+	w.head = 0
+}
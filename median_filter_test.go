@@ -0,0 +1,64 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedianFilter1(t *testing.T) {
+	_, err := NewMedianFilter(0)
+	assert.NotNil(t, err)
+
+	mf, err := NewMedianFilter(3)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(mf.Median()))
+	mf.AddSample(10)
+	assert.Equal(t, 10.0, mf.Median())
+	mf.AddSample(20)
+	assert.Equal(t, 15.0, mf.Median())
+	mf.AddSample(1000) //spike
+	assert.Equal(t, 20.0, mf.Median())
+	mf.AddSample(12)
+	assert.Equal(t, 20.0, mf.Median())
+	mf.AddSample(14)
+	assert.Equal(t, 14.0, mf.Median())
+	assert.Equal(t, 14.0, mf.Average())
+	assert.False(t, mf.AddSample(math.NaN()))
+
+	mf.Reset()
+	assert.True(t, math.IsNaN(mf.Median()))
+	mf.AddSample(-5)
+	assert.Equal(t, -5.0, mf.Median())
+}
+
+func TestMedianFilterDuplicates(t *testing.T) {
+	mf, _ := NewMedianFilter(4)
+	for _, v := range []float64{1, 1, 2, 2, 1, 3, 3, 3} {
+		mf.AddSample(v)
+	}
+	assert.Equal(t, 3.0, mf.Median())
+	assert.Equal(t, []float64{1, 3, 3, 3}, mf.window.sorted)
+}
+
+func TestTrimmedMean1(t *testing.T) {
+	_, err := NewTrimmedMean(10, 0.5)
+	assert.NotNil(t, err)
+
+	tm, err := NewTrimmedMean(10, 0.1)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(tm.Average()))
+	for _, v := range []float64{-1000, 10, 11, 9, 10, 10, 11, 9, 10, 5000} {
+		tm.AddSample(v)
+	}
+	assert.Equal(t, 10.0, tm.Average())
+
+	//sliding window drops oldest sample (-1000)
+	tm.AddSample(10)
+	assert.InDelta(t, 81.0/8, tm.Average(), 1e-9)
+
+	tm.Reset()
+	tm.AddSample(-3)
+	assert.Equal(t, -3.0, tm.Average())
+}
@@ -2,86 +2,135 @@ package signalutils
 
 import (
 	"math"
+	"sort"
 	"sync"
 	"time"
 )
 
+//Averager common interface of online averagers so that they can be swapped
+//(MovingAverage, ExponentialMovingAverage etc)
+type Averager interface {
+	AddSample(value float64) bool
+	Average() float64
+	Reset()
+}
+
 //MovingAverage running moving averager
+//Samples are kept in a circular buffer and a running (compensated) sum is maintained
+//so that adding samples and querying the average are O(1). Sliding window min/max
+//are tracked with monotonic deques so that they are amortized O(1) too
 //Only initialize this with NewMovingAverage(..)
 type MovingAverage struct {
-	Size                      int
+	//Size number of samples currently in window
+	Size int
+	//Samples circular buffer with samples. The oldest sample is at position 'head'
 	Samples                   []float64
-	lastResult                float64
-	lastResultTime            int64
-	lastResultValid           bool
-	lastMinResult             float64
-	lastMaxResult             float64
-	lastMinMaxResultTime      int64
-	lastMinMaxResultValid     bool
-	lastMinMaxResultGroupBy   int
+	head                      int
+	seq                       int64
+	sum                       kahanSum
+	weightedSum               kahanSum
+	welfordMean               float64
+	welfordM2                 float64
+	minWindow                 monotonicDeque
+	maxWindow                 monotonicDeque
+	groupedMinMax             groupedMinMax
 	samplesTimeUnixNano       []int64
 	samplesDurationNano       int64
 	lastSampleTimeUnixNano    int64
 	minTimeNanoBetweenSamples int64
-	m                         *sync.Mutex
+	rejected                  int64
+	clock                     Clock
+	//addM serializes additions so that acceptance policies are evaluated and samples are added atomically
+	addM *sync.Mutex
+	m    *sync.Mutex
 }
 
 //NewMovingAverage creates a new moving averager with a fixed size
 func NewMovingAverage(size int) MovingAverage {
 	return MovingAverage{
 		Samples:             make([]float64, size),
-		lastResultValid:     false,
+		minWindow:           newMonotonicDeque(false),
+		maxWindow:           newMonotonicDeque(true),
 		samplesDurationNano: -1,
+		clock:               SystemClock,
+		addM:                &sync.Mutex{},
 		m:                   &sync.Mutex{},
 	}
 }
 
 //NewMovingAverageTimeWindow creates a new moving averager that will average samples no older than 'samplesDuration', limiting the number of samples to 'maxSamples' in time window. If two consecutive samples are added to the averager in a period less than duration/maxSamples, it will be ignored.
 func NewMovingAverageTimeWindow(samplesDuration time.Duration, maxSamples int) MovingAverage {
+	return NewMovingAverageTimeWindowWithClock(samplesDuration, maxSamples, SystemClock)
+}
+
+//NewMovingAverageTimeWindowWithClock same as NewMovingAverageTimeWindow(..), but using 'clock' as time source
+func NewMovingAverageTimeWindowWithClock(samplesDuration time.Duration, maxSamples int, clock Clock) MovingAverage {
 	minTime := samplesDuration.Nanoseconds() / int64(maxSamples)
 	return MovingAverage{
 		Samples:                   make([]float64, maxSamples),
+		minWindow:                 newMonotonicDeque(false),
+		maxWindow:                 newMonotonicDeque(true),
 		samplesDurationNano:       samplesDuration.Nanoseconds(),
 		samplesTimeUnixNano:       make([]int64, maxSamples),
-		lastResultValid:           false,
 		minTimeNanoBetweenSamples: minTime,
 		lastSampleTimeUnixNano:    0,
+		clock:                     clock,
+		addM:                      &sync.Mutex{},
 		m:                         &sync.Mutex{},
 	}
 }
 
 //AddSample adds a new sample to the moving average. If there is more than 'size' samples, the oldest sample will be removed. If this is a timed window averager and the last sample was added in less than sampleDurate/maxSamples time, it will be ignored.
 func (m *MovingAverage) AddSample(value float64) bool {
+	m.addM.Lock()
+	defer m.addM.Unlock()
+	return m.addSample(value)
+}
+
+func (m *MovingAverage) addSample(value float64) bool {
 	m.m.Lock()
 	defer m.m.Unlock()
+	if len(m.Samples) == 0 {
+		return false
+	}
+	now := m.clock.Now().UnixNano()
 	if m.samplesDurationNano != -1 {
-		if (time.Now().UnixNano() - m.lastSampleTimeUnixNano) < m.minTimeNanoBetweenSamples {
+		if (now - m.lastSampleTimeUnixNano) < m.minTimeNanoBetweenSamples {
 			return false
 		}
+		m.expire(now)
 	}
 
-	m.lastSampleTimeUnixNano = time.Now().UnixNano()
+	m.lastSampleTimeUnixNano = now
+	m.push(value, now)
+	return true
+}
 
-	if m.Size < len(m.Samples) {
-		m.Size = m.Size + 1
-	} else {
-		//put new sample in tail
-		for i := 0; i < len(m.Samples)-1; i++ {
-			m.Samples[i] = m.Samples[i+1]
-			if m.samplesDurationNano != -1 {
-				m.samplesTimeUnixNano[i] = m.samplesTimeUnixNano[i+1]
-			}
-		}
+//push adds a sample added at time 'now' to the tail of the window, removing the oldest sample if the window is full
+func (m *MovingAverage) push(value float64, now int64) {
+	if m.Size == len(m.Samples) {
+		m.removeOldest()
 	}
-	m.Samples[m.Size-1] = value
 
-	//time window
+	//put new sample in tail
+	i := (m.head + m.Size) % len(m.Samples)
+	m.Samples[i] = value
 	if m.samplesDurationNano != -1 {
-		m.samplesTimeUnixNano[m.Size-1] = time.Now().UnixNano()
+		m.samplesTimeUnixNano[i] = now
 	}
-	m.lastResultValid = false
-	m.lastMinMaxResultValid = false
-	return true
+	m.Size = m.Size + 1
+	m.sum.add(value)
+	m.weightedSum.add(float64(m.Size) * value)
+	d := value - m.welfordMean
+	m.welfordMean = m.welfordMean + d/float64(m.Size)
+	m.welfordM2 = m.welfordM2 + d*(value-m.welfordMean)
+
+	m.minWindow.push(m.seq, value)
+	m.maxWindow.push(m.seq, value)
+	if m.groupedMinMax.groupBy > 0 {
+		m.groupedMinMax.push(m.seq, value)
+	}
+	m.seq = m.seq + 1
 }
 
 /*
@@ -89,118 +138,197 @@ AddSampleIfNearAverage Add sample only if its value is near current average to
 avoid espurious samples to be added to the average.
 *avgDiff* 1 means samples between [-currentAvg, +currentAvg] will be accepted.
 Returns true if sample was accepted
+Differently from RelativeBandPolicy, all samples are rejected while the current average is negative
 */
 func (m *MovingAverage) AddSampleIfNearAverage(value float64, avgDiff float64) bool {
-	avg := m.Average()
-	if math.IsNaN(avg) || (math.Abs(avg-value) <= (avg * avgDiff)) {
-		// fmt.Printf("ACCEPTED SAMPLE %f\n", value)
-		return m.AddSample(value)
+	return m.AddSampleIfAccepted(value, nearAveragePolicy{avgDiff})
+}
+
+//AddSampleIfAccepted adds sample only if it is accepted by 'policy' when compared to current samples
+//No other samples are added between the policy evaluation and the addition, so 'policy' must not add samples
+//to this averager. Rejected samples are counted and can be checked with Rejected()
+//Returns true if sample was accepted and added
+func (m *MovingAverage) AddSampleIfAccepted(value float64, policy AcceptancePolicy) bool {
+	m.addM.Lock()
+	defer m.addM.Unlock()
+	if !policy.Accept(m, value) {
+		m.m.Lock()
+		m.rejected = m.rejected + 1
+		m.m.Unlock()
+		return false
 	}
-	return false
+	return m.addSample(value)
+}
+
+//Rejected number of samples rejected by AddSampleIfNearAverage(..) or AddSampleIfAccepted(..) since this averager was created
+func (m *MovingAverage) Rejected() int64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.rejected
 }
 
 //Average computes average with current samples in fixed length list
 func (m *MovingAverage) Average() float64 {
 	m.m.Lock()
 	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
 	if m.Size == 0 {
 		return math.NaN()
 	}
+	return m.sum.value() / float64(m.Size)
+}
 
-	//invalidate cache if using timed window
-	if m.samplesDurationNano != -1 && m.lastResultValid {
-		if (time.Now().UnixNano() - m.lastResultTime) > m.minTimeNanoBetweenSamples {
-			m.lastResultValid = false
-		}
+//Count number of samples currently in window
+func (m *MovingAverage) Count() int {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
 	}
+	return m.Size
+}
 
-	// fmt.Printf("CACHE %v\n", m.lastResultValid)
-	n := 0
-	if !m.lastResultValid {
-		sum := 0.0
-		for i := 0; i < m.Size; i++ {
-			if m.samplesDurationNano != -1 {
-				//skip this sample if too old
-				if (time.Now().UnixNano() - m.samplesTimeUnixNano[i]) > m.samplesDurationNano {
-					// fmt.Printf("SKIP OLD %f i=%d\n", m.Samples[i], i)
-					continue
-				}
-			}
-			sum = sum + m.Samples[i]
-			n = n + 1
-		}
+//WeightedAverage computes the linearly weighted moving average (WMA) with current samples
+//The newest sample has weight N, the previous one N-1 and so on until the oldest sample, with weight 1
+func (m *MovingAverage) WeightedAverage() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	if m.Size == 0 {
+		return math.NaN()
+	}
+	n := float64(m.Size)
+	return m.weightedSum.value() / (n * (n + 1) / 2)
+}
 
-		m.lastResult = math.NaN()
-		if n > 0 {
-			m.lastResult = sum / float64(n)
-			// fmt.Printf("%f/%f=%f\n", sum, float64(n), m.lastResult)
-			m.lastResultValid = true
-			m.lastResultTime = time.Now().UnixNano()
-		}
+//Variance computes the sample variance of current samples
+//It is kept up to date with Welford's online algorithm as samples are added/expired
+//Returns NaN if there are less than two samples in window
+func (m *MovingAverage) Variance() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return m.variance()
+}
+
+//StdDev computes the sample standard deviation of current samples
+//Returns NaN if there are less than two samples in window
+func (m *MovingAverage) StdDev() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	return math.Sqrt(m.variance())
+}
+
+//ZScore computes how many standard deviations 'value' is away from the mean of current samples
+//Returns NaN if there are less than two samples in window
+func (m *MovingAverage) ZScore(value float64) float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	std := math.Sqrt(m.variance())
+	if math.IsNaN(std) {
+		return math.NaN()
+	}
+	return (value - m.welfordMean) / std
+}
+
+func (m *MovingAverage) variance() float64 {
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	if m.Size < 2 {
+		return math.NaN()
 	}
-	return m.lastResult
+	return m.welfordM2 / float64(m.Size-1)
+}
+
+//Median returns the median of current samples. This is O(n log n)
+//Returns NaN if there are no samples
+func (m *MovingAverage) Median() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	return median(m.values())
+}
+
+//MedianAbsoluteDeviation returns the median of current samples and the median of the
+//absolute deviations of current samples from it (MAD). This is O(n log n)
+//Returns NaN if there are no samples
+func (m *MovingAverage) MedianAbsoluteDeviation() (float64, float64) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	return medianAbsoluteDeviation(m.values())
+}
+
+//Min returns the minimum value in current window
+func (m *MovingAverage) Min() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	v, _ := m.minWindow.front()
+	return v
+}
+
+//Max returns the maximum value in current window
+func (m *MovingAverage) Max() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	v, _ := m.maxWindow.front()
+	return v
 }
 
 //AverageMinMax - returns the min/max values in current window
 //Group min/max each 'groupBySamples' and perform average over theses samples for min and max values
+//Groups are formed from the oldest sample in window, so the last group may have less samples
+//Group stats are updated incrementally as samples are added/expired, so this is O(groupBySamples).
+//Changing 'groupBySamples' between calls causes the stats to be rebuilt from current samples
 func (m *MovingAverage) AverageMinMax(groupBySamples int) (float64, float64) {
 	m.m.Lock()
 	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
 	if m.Size == 0 {
 		return math.NaN(), math.NaN()
 	}
-
-	//invalidate cache if using timed window
-	if m.samplesDurationNano != -1 && m.lastMinMaxResultValid {
-		if (time.Now().UnixNano() - m.lastMinMaxResultTime) > m.minTimeNanoBetweenSamples {
-			m.lastMinMaxResultValid = false
-		}
+	if groupBySamples < 1 {
+		groupBySamples = 1
 	}
-
-	// fmt.Printf("CACHE %v\n", m.lastResultValid)
-	n := 0
-	if !m.lastMinMaxResultValid {
-		sumMin := 0.0
-		sumMax := 0.0
-		currMin := math.MaxFloat64
-		currMax := -math.MaxFloat64
+	oldest := m.seq - int64(m.Size)
+	if m.groupedMinMax.groupBy != int64(groupBySamples) {
+		m.groupedMinMax = newGroupedMinMax(groupBySamples)
 		for i := 0; i < m.Size; i++ {
-			if m.samplesDurationNano != -1 {
-				//skip this sample if too old
-				if (time.Now().UnixNano() - m.samplesTimeUnixNano[i]) > m.samplesDurationNano {
-					// fmt.Printf("SKIP OLD %f i=%d\n", m.Samples[i], i)
-					continue
-				}
-			}
-			v := m.Samples[i]
-			if v < currMin {
-				currMin = v
-			}
-			if v > currMax {
-				currMax = v
-			}
-			// fmt.Printf("v=%f currMin=%f currMax=%f i=%d\n", v, currMin, currMax, i)
-			if (i+1)%groupBySamples == 0 || i == (m.Size-1) {
-				sumMin = sumMin + currMin
-				sumMax = sumMax + currMax
-				n = n + 1
-
-				currMin = math.MaxFloat64
-				currMax = -math.MaxFloat64
-				// fmt.Printf("SUM MIN=%f MAX=%f n=%d\n", sumMin, sumMax, n)
-			}
+			m.groupedMinMax.push(oldest+int64(i), m.Samples[(m.head+i)%len(m.Samples)])
 		}
-
-		m.lastMinResult = math.NaN()
-		m.lastMaxResult = math.NaN()
-		if n > 0 {
-			m.lastMinResult = sumMin / float64(n)
-			m.lastMaxResult = sumMax / float64(n)
-			m.lastMinMaxResultValid = true
-			m.lastMinMaxResultTime = time.Now().UnixNano()
+	}
+	sumMin, sumMax := m.groupedMinMax.sums(oldest)
+	n := m.Size / groupBySamples
+	partial := m.Size % groupBySamples
+	if partial > 0 {
+		min := math.MaxFloat64
+		max := -math.MaxFloat64
+		for i := m.Size - partial; i < m.Size; i++ {
+			v := m.Samples[(m.head+i)%len(m.Samples)]
+			min = math.Min(min, v)
+			max = math.Max(max, v)
 		}
+		sumMin = sumMin + min
+		sumMax = sumMax + max
+		n = n + 1
 	}
-	return m.lastMinResult, m.lastMaxResult
+	return sumMin / float64(n), sumMax / float64(n)
 }
 
 //Reset internal samples
@@ -208,6 +336,107 @@ func (m *MovingAverage) Reset() {
 	m.m.Lock()
 	defer m.m.Unlock()
 	m.Samples = make([]float64, len(m.Samples))
+	if m.samplesDurationNano != -1 {
+		m.samplesTimeUnixNano = make([]int64, len(m.samplesTimeUnixNano))
+	}
 	m.Size = 0
-	m.lastResultValid = false
+	m.head = 0
+	m.sum = kahanSum{}
+	m.weightedSum = kahanSum{}
+	m.welfordMean = 0
+	m.welfordM2 = 0
+	m.minWindow.reset()
+	m.maxWindow.reset()
+	m.groupedMinMax.reset()
+}
+
+//values returns a copy of current samples ordered from oldest to newest
+func (m *MovingAverage) values() []float64 {
+	vs := make([]float64, m.Size)
+	for i := 0; i < m.Size; i++ {
+		vs[i] = m.Samples[(m.head+i)%len(m.Samples)]
+	}
+	return vs
+}
+
+//expire removes samples older than the time window from the head of the buffer
+func (m *MovingAverage) expire(now int64) {
+	for m.Size > 0 && (now-m.samplesTimeUnixNano[m.head]) > m.samplesDurationNano {
+		// fmt.Printf("SKIP OLD %f i=%d\n", m.Samples[m.head], m.head)
+		m.removeOldest()
+	}
+}
+
+func (m *MovingAverage) removeOldest() {
+	seq := m.seq - int64(m.Size)
+	m.minWindow.pop(seq)
+	m.maxWindow.pop(seq)
+	if m.groupedMinMax.groupBy > 0 {
+		m.groupedMinMax.pop(seq)
+	}
+	//all weights are decremented by one, so the oldest sample weight becomes zero
+	m.weightedSum.add(-m.sum.value())
+	v := m.Samples[m.head]
+	m.sum.add(-v)
+	m.head = (m.head + 1) % len(m.Samples)
+	m.Size = m.Size - 1
+	if m.Size == 0 {
+		//avoid accumulating rounding errors while empty
+		m.head = 0
+		m.sum = kahanSum{}
+		m.weightedSum = kahanSum{}
+		m.welfordMean = 0
+		m.welfordM2 = 0
+		return
+	}
+	//reverse Welford update
+	d := v - m.welfordMean
+	m.welfordMean = m.welfordMean - d/float64(m.Size)
+	m.welfordM2 = m.welfordM2 - d*(v-m.welfordMean)
+	if m.welfordM2 < 0 {
+		m.welfordM2 = 0
+	}
+}
+
+//median sorts 'values' in place and returns its median
+func median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+//medianAbsoluteDeviation computes median and MAD of 'values'. 'values' is changed
+func medianAbsoluteDeviation(values []float64) (float64, float64) {
+	med := median(values)
+	for i, v := range values {
+		values[i] = math.Abs(v - med)
+	}
+	return med, median(values)
+}
+
+//kahanSum running sum with Kahan-Babuska (Neumaier) compensation
+//so that adding and removing values a lot of times doesn't accumulate rounding errors
+type kahanSum struct {
+	sum          float64
+	compensation float64
+}
+
+func (k *kahanSum) add(v float64) {
+	t := k.sum + v
+	if math.Abs(k.sum) >= math.Abs(v) {
+		k.compensation = k.compensation + ((k.sum - t) + v)
+	} else {
+		k.compensation = k.compensation + ((v - t) + k.sum)
+	}
+	k.sum = t
+}
+
+func (k *kahanSum) value() float64 {
+	return k.sum + k.compensation
 }
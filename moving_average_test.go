@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/gonum/stat"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -177,3 +178,215 @@ func TestMovingAverageMinMax2(t *testing.T) {
 		assert.LessOrEqualf(t, max-min, 15.0, "max-min average should be low")
 	}
 }
+
+func TestMovingAverageTimeWindowManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ma := NewMovingAverageTimeWindowWithClock(500*time.Millisecond, 5, c)
+	ma.AddSample(10000)
+	c.Advance(200 * time.Millisecond)
+
+	ma.AddSample(1000)
+	c.Advance(105 * time.Millisecond)
+	ma.AddSample(2000)
+	c.Advance(105 * time.Millisecond)
+	ma.AddSample(3000)
+	c.Advance(105 * time.Millisecond)
+	ma.AddSample(4000)
+	c.Advance(105 * time.Millisecond)
+	ma.AddSample(5000)
+	assert.Equal(t, 3000.0, ma.Average())
+
+	c.Advance(200 * time.Millisecond)
+	assert.Equal(t, 4000.0, ma.Average())
+
+	c.Advance(400 * time.Millisecond)
+	assert.True(t, math.IsNaN(ma.Average()))
+
+	ma.AddSample(5000)
+	assert.Equal(t, 5000.0, ma.Average())
+	ma.AddSample(10000)
+	assert.Equal(t, 5000.0, ma.Average())
+}
+
+func TestMovingAverageRingBuffer(t *testing.T) {
+	ma := NewMovingAverage(3)
+	for i := 1; i <= 10; i++ {
+		ma.AddSample(float64(i))
+		if i >= 3 {
+			assert.Equal(t, float64(i-1), ma.Average())
+		}
+	}
+	assert.Equal(t, 3, ma.Size)
+
+	ma.Reset()
+	assert.Equal(t, 0, ma.Size)
+	assert.True(t, math.IsNaN(ma.Average()))
+	ma.AddSample(7)
+	assert.Equal(t, 7.0, ma.Average())
+}
+
+func TestMovingAverageRunningSumPrecision(t *testing.T) {
+	ma := NewMovingAverage(100000)
+	for i := 0; i < 1000000; i++ {
+		if i%2 == 0 {
+			ma.AddSample(1e10)
+		} else {
+			ma.AddSample(0.1)
+		}
+	}
+	for i := 0; i < 100000; i++ {
+		ma.AddSample(0.1)
+	}
+	assert.InDeltaf(t, 0.1, ma.Average(), 1e-9, "")
+}
+
+func BenchmarkMovingAverageAddSample(b *testing.B) {
+	ma := NewMovingAverage(100000)
+	for i := 0; i < b.N; i++ {
+		ma.AddSample(float64(i))
+		ma.Average()
+	}
+}
+
+func TestMovingAverageMinMaxSliding(t *testing.T) {
+	ma := NewMovingAverage(4)
+	assert.True(t, math.IsNaN(ma.Min()))
+	assert.True(t, math.IsNaN(ma.Max()))
+	values := []float64{5, 3, 8, 1, 9, 2, 2, 7, 4, 6}
+	for i, v := range values {
+		ma.AddSample(v)
+		from := i - 3
+		if from < 0 {
+			from = 0
+		}
+		min := math.MaxFloat64
+		max := -math.MaxFloat64
+		for _, w := range values[from : i+1] {
+			min = math.Min(min, w)
+			max = math.Max(max, w)
+		}
+		assert.Equal(t, min, ma.Min())
+		assert.Equal(t, max, ma.Max())
+	}
+}
+
+func TestMovingAverageMinMaxGroupedRandom(t *testing.T) {
+	for _, c := range []struct{ size, groupBy int }{{17, 4}, {16, 4}, {5, 1}, {3, 7}, {20, 20}} {
+		ma := NewMovingAverage(c.size)
+		values := make([]float64, 0)
+		for i := 0; i < 500; i++ {
+			v := float64(rand.Intn(1000))
+			values = append(values, v)
+			ma.AddSample(v)
+			min, max := ma.AverageMinMax(c.groupBy)
+
+			//brute force with groups formed from the oldest sample in window
+			from := len(values) - c.size
+			if from < 0 {
+				from = 0
+			}
+			sumMin, sumMax, n := 0.0, 0.0, 0
+			for g := from; g < len(values); g = g + c.groupBy {
+				gmin := math.MaxFloat64
+				gmax := -math.MaxFloat64
+				for j := g; j < g+c.groupBy && j < len(values); j++ {
+					gmin = math.Min(gmin, values[j])
+					gmax = math.Max(gmax, values[j])
+				}
+				sumMin = sumMin + gmin
+				sumMax = sumMax + gmax
+				n = n + 1
+			}
+			assert.InDelta(t, sumMin/float64(n), min, 1e-9)
+			assert.InDelta(t, sumMax/float64(n), max, 1e-9)
+		}
+	}
+}
+
+func TestMovingAverageMinMaxTimeWindowManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ma := NewMovingAverageTimeWindowWithClock(1*time.Second, 10, c)
+	ma.AddSample(100)
+	c.Advance(500 * time.Millisecond)
+	ma.AddSample(10)
+	c.Advance(200 * time.Millisecond)
+	ma.AddSample(50)
+	assert.Equal(t, 10.0, ma.Min())
+	assert.Equal(t, 100.0, ma.Max())
+	min, max := ma.AverageMinMax(2)
+	assert.Equal(t, 30.0, min)
+	assert.Equal(t, 75.0, max)
+
+	c.Advance(400 * time.Millisecond)
+	assert.Equal(t, 10.0, ma.Min())
+	assert.Equal(t, 50.0, ma.Max())
+	min, max = ma.AverageMinMax(2)
+	assert.Equal(t, 10.0, min)
+	assert.Equal(t, 50.0, max)
+
+	c.Advance(1 * time.Second)
+	assert.True(t, math.IsNaN(ma.Max()))
+	min, _ = ma.AverageMinMax(2)
+	assert.True(t, math.IsNaN(min))
+}
+
+func BenchmarkMovingAverageMinMax(b *testing.B) {
+	ma := NewMovingAverage(100000)
+	for i := 0; i < b.N; i++ {
+		ma.AddSample(float64(rand.Intn(1000)))
+		ma.AverageMinMax(10)
+	}
+}
+
+func TestMovingAverageVariance(t *testing.T) {
+	ma := NewMovingAverage(5)
+	assert.True(t, math.IsNaN(ma.Variance()))
+	ma.AddSample(10)
+	assert.True(t, math.IsNaN(ma.StdDev()))
+	assert.True(t, math.IsNaN(ma.ZScore(10)))
+	ma.AddSample(15)
+	ma.AddSample(10)
+	ma.AddSample(5)
+	ma.AddSample(10)
+	assert.InDelta(t, 12.5, ma.Variance(), 1e-9)
+	assert.InDelta(t, 3.5355, ma.StdDev(), 1e-4)
+	assert.InDelta(t, 2.0, ma.ZScore(10+2*ma.StdDev()), 1e-9)
+	assert.InDelta(t, -1.0, ma.ZScore(10-ma.StdDev()), 1e-9)
+
+	ma.Reset()
+	assert.True(t, math.IsNaN(ma.Variance()))
+}
+
+func TestMovingAverageVarianceSliding(t *testing.T) {
+	ma := NewMovingAverage(50)
+	values := make([]float64, 0)
+	for i := 0; i < 2000; i++ {
+		v := 1e6 + float64(rand.Intn(1000))/10
+		values = append(values, v)
+		ma.AddSample(v)
+		if len(values) < 2 {
+			continue
+		}
+		window := values
+		if len(window) > 50 {
+			window = window[len(window)-50:]
+		}
+		assert.InDelta(t, stat.Variance(window, nil), ma.Variance(), 1e-6)
+	}
+}
+
+func TestMovingAverageVarianceTimeWindow(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ma := NewMovingAverageTimeWindowWithClock(1*time.Second, 10, c)
+	ma.AddSample(1000)
+	c.Advance(600 * time.Millisecond)
+	ma.AddSample(10)
+	c.Advance(200 * time.Millisecond)
+	ma.AddSample(20)
+	assert.InDelta(t, stat.Variance([]float64{1000, 10, 20}, nil), ma.Variance(), 1e-9)
+	c.Advance(300 * time.Millisecond)
+	assert.InDelta(t, 50.0, ma.Variance(), 1e-9)
+	assert.InDelta(t, 0.0, ma.ZScore(15), 1e-9)
+	c.Advance(600 * time.Millisecond)
+	assert.True(t, math.IsNaN(ma.Variance()))
+}
@@ -0,0 +1,100 @@
+package signalutils
+
+import (
+	"math"
+)
+
+//DoubleExponentialAverage computes the double exponential moving average (DEMA) over current samples
+//using alpha 2/(N+1), where N is the number of samples in window. DEMA = 2*EMA - EMA(EMA)
+//The EMAs are seeded with the oldest sample in window. This is O(N)
+func (m *MovingAverage) DoubleExponentialAverage() float64 {
+	e1, e2, _, ok := m.cascadedEMA()
+	if !ok {
+		return math.NaN()
+	}
+	return 2*e1 - e2
+}
+
+//TripleExponentialAverage computes the triple exponential moving average (TEMA) over current samples
+//using alpha 2/(N+1), where N is the number of samples in window. TEMA = 3*EMA - 3*EMA(EMA) + EMA(EMA(EMA))
+//The EMAs are seeded with the oldest sample in window. This is O(N)
+func (m *MovingAverage) TripleExponentialAverage() float64 {
+	e1, e2, e3, ok := m.cascadedEMA()
+	if !ok {
+		return math.NaN()
+	}
+	return 3*e1 - 3*e2 + e3
+}
+
+//HullAverage computes the Hull moving average (HMA) over current samples
+//HMA = WMA(2*WMA(N/2) - WMA(N), sqrt(N)), where N is the number of samples in window
+//For the first points of the inner WMAs, that would need samples older than the window, only
+//the available samples are used. This is O(N)
+func (m *MovingAverage) HullAverage() float64 {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	if m.Size == 0 {
+		return math.NaN()
+	}
+	vs := m.values()
+	n := len(vs)
+
+	//prefix sums so that the WMA ending at any point is O(1)
+	p := make([]float64, n+1)
+	q := make([]float64, n+1)
+	for j, v := range vs {
+		p[j+1] = p[j] + v
+		q[j+1] = q[j] + float64(j)*v
+	}
+	wma := func(end int, period int) float64 {
+		start := end - period + 1
+		if start < 0 {
+			start = 0
+		}
+		l := float64(end - start + 1)
+		s := q[end+1] - q[start] - float64(start-1)*(p[end+1]-p[start])
+		return s / (l * (l + 1) / 2)
+	}
+
+	half := n / 2
+	if half < 1 {
+		half = 1
+	}
+	sq := int(math.Round(math.Sqrt(float64(n))))
+	if sq < 1 {
+		sq = 1
+	}
+	sum := 0.0
+	weights := 0.0
+	for i := 0; i < sq; i++ {
+		end := n - sq + i
+		raw := 2*wma(end, half) - wma(end, n)
+		w := float64(i + 1)
+		sum = sum + w*raw
+		weights = weights + w
+	}
+	return sum / weights
+}
+
+func (m *MovingAverage) cascadedEMA() (e1 float64, e2 float64, e3 float64, ok bool) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.samplesDurationNano != -1 {
+		m.expire(m.clock.Now().UnixNano())
+	}
+	if m.Size == 0 {
+		return math.NaN(), math.NaN(), math.NaN(), false
+	}
+	vs := m.values()
+	alpha := 2 / (float64(len(vs)) + 1)
+	e1, e2, e3 = vs[0], vs[0], vs[0]
+	for _, v := range vs[1:] {
+		e1 = alpha*v + (1-alpha)*e1
+		e2 = alpha*e1 + (1-alpha)*e2
+		e3 = alpha*e2 + (1-alpha)*e3
+	}
+	return e1, e2, e3, true
+}
@@ -0,0 +1,76 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWeightedAverage(t *testing.T) {
+	ma := NewMovingAverage(3)
+	assert.True(t, math.IsNaN(ma.WeightedAverage()))
+	ma.AddSample(1)
+	assert.Equal(t, 1.0, ma.WeightedAverage())
+	ma.AddSample(2)
+	ma.AddSample(3)
+	assert.InDelta(t, 14.0/6, ma.WeightedAverage(), 1e-9)
+	ma.AddSample(4)
+	assert.InDelta(t, 20.0/6, ma.WeightedAverage(), 1e-9)
+	for i := 0; i < 1000; i++ {
+		ma.AddSample(float64(i))
+	}
+	assert.InDelta(t, (997.0+998*2+999*3)/6, ma.WeightedAverage(), 1e-9)
+	ma.Reset()
+	assert.True(t, math.IsNaN(ma.WeightedAverage()))
+}
+
+func TestWeightedAverageTimeWindow(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ma := NewMovingAverageTimeWindowWithClock(1*time.Second, 10, c)
+	ma.AddSample(100)
+	c.Advance(600 * time.Millisecond)
+	ma.AddSample(10)
+	c.Advance(200 * time.Millisecond)
+	ma.AddSample(40)
+	assert.InDelta(t, (100.0+20+120)/6, ma.WeightedAverage(), 1e-9)
+	c.Advance(300 * time.Millisecond)
+	assert.InDelta(t, (10.0+80)/3, ma.WeightedAverage(), 1e-9)
+}
+
+func TestTrendAveragesConstant(t *testing.T) {
+	ma := NewMovingAverage(20)
+	assert.True(t, math.IsNaN(ma.DoubleExponentialAverage()))
+	assert.True(t, math.IsNaN(ma.TripleExponentialAverage()))
+	assert.True(t, math.IsNaN(ma.HullAverage()))
+	for i := 0; i < 30; i++ {
+		ma.AddSample(50)
+	}
+	assert.InDelta(t, 50.0, ma.DoubleExponentialAverage(), 1e-9)
+	assert.InDelta(t, 50.0, ma.TripleExponentialAverage(), 1e-9)
+	assert.InDelta(t, 50.0, ma.HullAverage(), 1e-9)
+}
+
+func TestTrendAveragesLag(t *testing.T) {
+	ma := NewMovingAverage(20)
+	for i := 0; i < 100; i++ {
+		ma.AddSample(float64(i))
+	}
+	//on a ramp, trend following averages lag less than the simple average
+	last := 99.0
+	avgLag := last - ma.Average()
+	assert.Less(t, last-ma.WeightedAverage(), avgLag)
+	assert.Less(t, last-ma.DoubleExponentialAverage(), avgLag)
+	assert.Less(t, math.Abs(last-ma.TripleExponentialAverage()), last-ma.DoubleExponentialAverage())
+	assert.Less(t, math.Abs(last-ma.HullAverage()), last-ma.WeightedAverage())
+}
+
+func TestHullAverage(t *testing.T) {
+	ma := NewMovingAverage(4)
+	ma.AddSample(1)
+	ma.AddSample(2)
+	ma.AddSample(3)
+	ma.AddSample(4)
+	assert.InDelta(t, 35.0/9, ma.HullAverage(), 1e-9)
+}
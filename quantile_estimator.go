@@ -0,0 +1,331 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//QuantileEstimator streaming quantile estimator (p50, p95, p99 etc) based on a merging t-digest.
+//Memory is bounded by 'compression' centroids. Quantiles near the tails (q close to 0 or 1) are
+//more accurate than the median. The rank error is roughly proportional to q*(1-q)/compression, so
+//with compression 100 the error on p99 is usually below 0.1% and on p50 below 1%
+//Digests of different estimators can be merged, so that per goroutine estimators can be combined
+//Only initialize this with NewQuantileEstimator(..) or NewQuantileEstimatorTimeWindow(..)
+type QuantileEstimator struct {
+	compression         float64
+	digests             []*tdigest
+	digestsStartNano    []int64
+	bucketDurationNano  int64
+	samplesDurationNano int64
+	clock               Clock
+	m                   *sync.Mutex
+}
+
+//NewQuantileEstimator creates a new quantile estimator that considers all samples ever added
+//'compression' controls accuracy versus memory usage. 100 is a good value
+func NewQuantileEstimator(compression float64) (QuantileEstimator, error) {
+	if compression < 10 {
+		return QuantileEstimator{}, fmt.Errorf("compression must be at least 10")
+	}
+	return QuantileEstimator{
+		compression:         compression,
+		digests:             []*tdigest{newTDigest(compression)},
+		digestsStartNano:    []int64{0},
+		samplesDurationNano: -1,
+		clock:               SystemClock,
+		m:                   &sync.Mutex{},
+	}, nil
+}
+
+//NewQuantileEstimatorTimeWindow creates a new quantile estimator that considers only samples no older than 'samplesDuration'.
+//Samples are kept in 'buckets' digests, each one spanning samplesDuration/buckets. Older samples expire one whole bucket at a time,
+//so more buckets means a more precise window, but more memory usage
+func NewQuantileEstimatorTimeWindow(samplesDuration time.Duration, buckets int, compression float64) (QuantileEstimator, error) {
+	return NewQuantileEstimatorTimeWindowWithClock(samplesDuration, buckets, compression, SystemClock)
+}
+
+//NewQuantileEstimatorTimeWindowWithClock same as NewQuantileEstimatorTimeWindow(..), but using 'clock' as time source
+func NewQuantileEstimatorTimeWindowWithClock(samplesDuration time.Duration, buckets int, compression float64, clock Clock) (QuantileEstimator, error) {
+	if compression < 10 {
+		return QuantileEstimator{}, fmt.Errorf("compression must be at least 10")
+	}
+	if buckets < 1 {
+		return QuantileEstimator{}, fmt.Errorf("buckets must be at least 1")
+	}
+	bd := samplesDuration.Nanoseconds() / int64(buckets)
+	if bd <= 0 {
+		return QuantileEstimator{}, fmt.Errorf("samplesDuration too short for %d buckets", buckets)
+	}
+	digests := make([]*tdigest, buckets)
+	starts := make([]int64, buckets)
+	for i := range digests {
+		digests[i] = newTDigest(compression)
+		starts[i] = math.MinInt64
+	}
+	return QuantileEstimator{
+		compression:         compression,
+		digests:             digests,
+		digestsStartNano:    starts,
+		bucketDurationNano:  bd,
+		samplesDurationNano: samplesDuration.Nanoseconds(),
+		clock:               clock,
+		m:                   &sync.Mutex{},
+	}, nil
+}
+
+//AddSample adds a new sample to the estimator. NaN values are ignored (returns false)
+func (q *QuantileEstimator) AddSample(value float64) bool {
+	if math.IsNaN(value) {
+		return false
+	}
+	q.m.Lock()
+	defer q.m.Unlock()
+	q.currentDigest().add(value, 1)
+	return true
+}
+
+//Quantile estimates the value below which a fraction 'quantile' (0-1) of the samples are
+//Returns NaN if there are no samples
+func (q *QuantileEstimator) Quantile(quantile float64) float64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.windowDigest().quantile(quantile)
+}
+
+//Count number of samples currently considered by the estimator
+func (q *QuantileEstimator) Count() float64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.windowDigest().count
+}
+
+//Merge adds all samples from 'other' estimator to this one. 'other' is not changed
+//If both estimators are time windowed with the same window configuration, samples are merged keeping
+//their time buckets, otherwise all samples from 'other' are merged into the current bucket of this estimator
+func (q *QuantileEstimator) Merge(other *QuantileEstimator) {
+	if other == q {
+		return
+	}
+	other.m.Lock()
+	sameWindow := q.samplesDurationNano != -1 &&
+		other.samplesDurationNano == q.samplesDurationNano &&
+		other.bucketDurationNano == q.bucketDurationNano &&
+		len(other.digests) == len(q.digests)
+	digests := make([]*tdigest, 0)
+	starts := make([]int64, 0)
+	if sameWindow {
+		for i, d := range other.digests {
+			digests = append(digests, d.clone())
+			starts = append(starts, other.digestsStartNano[i])
+		}
+	} else {
+		digests = append(digests, other.windowDigest().clone())
+		starts = append(starts, 0)
+	}
+	other.m.Unlock()
+
+	q.m.Lock()
+	defer q.m.Unlock()
+	if !sameWindow {
+		q.currentDigest().merge(digests[0])
+		return
+	}
+	oldest := q.clock.Now().UnixNano() - q.samplesDurationNano - q.bucketDurationNano
+	for i, d := range digests {
+		if starts[i] < oldest {
+			continue
+		}
+		//both use the same bucket alignment, so the bucket position is the same
+		if q.digestsStartNano[i] < starts[i] {
+			q.digests[i].reset()
+			q.digestsStartNano[i] = starts[i]
+		}
+		if q.digestsStartNano[i] == starts[i] {
+			q.digests[i].merge(d)
+		}
+	}
+}
+
+//Reset remove all samples
+func (q *QuantileEstimator) Reset() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for i, d := range q.digests {
+		d.reset()
+		if q.samplesDurationNano != -1 {
+			q.digestsStartNano[i] = math.MinInt64
+		}
+	}
+}
+
+//currentDigest returns the digest in which new samples must be added
+func (q *QuantileEstimator) currentDigest() *tdigest {
+	if q.samplesDurationNano == -1 {
+		return q.digests[0]
+	}
+	now := q.clock.Now().UnixNano()
+	start := now - (now % q.bucketDurationNano)
+	i := int((start / q.bucketDurationNano) % int64(len(q.digests)))
+	if i < 0 {
+		i = i + len(q.digests)
+	}
+	if q.digestsStartNano[i] != start {
+		q.digests[i].reset()
+		q.digestsStartNano[i] = start
+	}
+	return q.digests[i]
+}
+
+//windowDigest returns a digest with all the samples inside the time window
+func (q *QuantileEstimator) windowDigest() *tdigest {
+	if q.samplesDurationNano == -1 {
+		return q.digests[0]
+	}
+	from := q.clock.Now().UnixNano() - q.samplesDurationNano
+	td := newTDigest(q.compression)
+	for i, d := range q.digests {
+		//bucket overlaps time window
+		if q.digestsStartNano[i] != math.MinInt64 && q.digestsStartNano[i]+q.bucketDurationNano > from {
+			td.merge(d)
+		}
+	}
+	return td
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+//tdigest merging t-digest as described in "Computing extremely accurate quantiles using t-digests" (Dunning, Ertl)
+type tdigest struct {
+	compression float64
+	centroids   []centroid
+	buffer      []centroid
+	count       float64
+	min         float64
+	max         float64
+}
+
+func newTDigest(compression float64) *tdigest {
+	return &tdigest{
+		compression: compression,
+		centroids:   make([]centroid, 0),
+		buffer:      make([]centroid, 0),
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (t *tdigest) add(value float64, weight float64) {
+	t.buffer = append(t.buffer, centroid{value, weight})
+	t.count = t.count + weight
+	t.min = math.Min(t.min, value)
+	t.max = math.Max(t.max, value)
+	if len(t.buffer) >= int(5*t.compression) {
+		t.compress()
+	}
+}
+
+func (t *tdigest) merge(other *tdigest) {
+	for _, c := range other.centroids {
+		t.add(c.mean, c.weight)
+	}
+	for _, c := range other.buffer {
+		t.add(c.mean, c.weight)
+	}
+	t.min = math.Min(t.min, other.min)
+	t.max = math.Max(t.max, other.max)
+}
+
+func (t *tdigest) clone() *tdigest {
+	c := newTDigest(t.compression)
+	c.centroids = append(c.centroids, t.centroids...)
+	c.buffer = append(c.buffer, t.buffer...)
+	c.count = t.count
+	c.min = t.min
+	c.max = t.max
+	return c
+}
+
+func (t *tdigest) reset() {
+	t.centroids = t.centroids[:0]
+	t.buffer = t.buffer[:0]
+	t.count = 0
+	t.min = math.Inf(1)
+	t.max = math.Inf(-1)
+}
+
+//compress merges buffered samples into centroids, limiting the size of each centroid
+//according to its position so that centroids near the tails are small
+func (t *tdigest) compress() {
+	if len(t.buffer) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buffer...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+	merged := make([]centroid, 0, len(t.centroids)+1)
+	cur := all[0]
+	wSoFar := 0.0
+	for _, c := range all[1:] {
+		proposed := cur.weight + c.weight
+		q0 := wSoFar / t.count
+		q2 := (wSoFar + proposed) / t.count
+		maxSize := 4 * t.count * math.Min(q0*(1-q0), q2*(1-q2)) / t.compression
+		if proposed <= maxSize {
+			cur.mean = cur.mean + (c.mean-cur.mean)*c.weight/proposed
+			cur.weight = proposed
+			continue
+		}
+		wSoFar = wSoFar + cur.weight
+		merged = append(merged, cur)
+		cur = c
+	}
+	merged = append(merged, cur)
+	t.centroids = merged
+	t.buffer = make([]centroid, 0)
+}
+
+func (t *tdigest) quantile(q float64) float64 {
+	if t.count == 0 || q < 0 || q > 1 {
+		return math.NaN()
+	}
+	t.compress()
+	cs := t.centroids
+	if len(cs) == 1 || q == 0 {
+		if q == 1 {
+			return t.max
+		}
+		if q == 0 {
+			return t.min
+		}
+		return cs[0].mean
+	}
+	if q == 1 {
+		return t.max
+	}
+
+	target := q * t.count
+	//before the center of the first centroid, interpolate with min value
+	if target < cs[0].weight/2 {
+		return t.min + (cs[0].mean-t.min)*target/(cs[0].weight/2)
+	}
+	cum := 0.0
+	for i := 0; i < len(cs)-1; i++ {
+		left := cum + cs[i].weight/2
+		right := cum + cs[i].weight + cs[i+1].weight/2
+		if target <= right {
+			return cs[i].mean + (cs[i+1].mean-cs[i].mean)*(target-left)/(right-left)
+		}
+		cum = cum + cs[i].weight
+	}
+	//after the center of the last centroid, interpolate with max value
+	last := cs[len(cs)-1]
+	left := t.count - last.weight/2
+	return last.mean + (t.max-last.mean)*(target-left)/(last.weight/2)
+}
@@ -0,0 +1,125 @@
+package signalutils
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantileEstimator1(t *testing.T) {
+	_, err := NewQuantileEstimator(1)
+	assert.NotNil(t, err)
+
+	qe, err := NewQuantileEstimator(100)
+	assert.Nil(t, err)
+	assert.True(t, math.IsNaN(qe.Quantile(0.5)))
+
+	qe.AddSample(10)
+	assert.Equal(t, 10.0, qe.Quantile(0.5))
+	assert.Equal(t, 10.0, qe.Quantile(0.99))
+
+	qe.Reset()
+	for i := 1; i <= 100000; i++ {
+		qe.AddSample(float64(i))
+	}
+	assert.Equal(t, 100000.0, qe.Count())
+	assert.InDelta(t, 50000.0, qe.Quantile(0.5), 500)
+	assert.InDelta(t, 95000.0, qe.Quantile(0.95), 200)
+	assert.InDelta(t, 99000.0, qe.Quantile(0.99), 100)
+	assert.Equal(t, 1.0, qe.Quantile(0))
+	assert.Equal(t, 100000.0, qe.Quantile(1))
+	assert.True(t, math.IsNaN(qe.Quantile(1.1)))
+}
+
+func TestQuantileEstimatorRandom(t *testing.T) {
+	qe, _ := NewQuantileEstimator(100)
+	values := make([]float64, 0)
+	for i := 0; i < 50000; i++ {
+		//latencies like distribution
+		v := rand.ExpFloat64() * 100
+		values = append(values, v)
+		qe.AddSample(v)
+	}
+	sort.Float64s(values)
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+		exact := values[int(q*float64(len(values)))]
+		assert.InDeltaf(t, exact, qe.Quantile(q), exact*0.02, "q=%f", q)
+	}
+}
+
+func TestQuantileEstimatorMerge(t *testing.T) {
+	total, _ := NewQuantileEstimator(100)
+	wg := sync.WaitGroup{}
+	m := sync.Mutex{}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			qe, _ := NewQuantileEstimator(100)
+			for i := 0; i < 10000; i++ {
+				qe.AddSample(float64(g*10000 + i))
+			}
+			m.Lock()
+			total.Merge(&qe)
+			m.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 40000.0, total.Count())
+	assert.InDelta(t, 20000.0, total.Quantile(0.5), 400)
+	assert.InDelta(t, 39600.0, total.Quantile(0.99), 100)
+	assert.Equal(t, 0.0, total.Quantile(0))
+	assert.Equal(t, 39999.0, total.Quantile(1))
+}
+
+func TestQuantileEstimatorTimeWindow(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	qe, err := NewQuantileEstimatorTimeWindowWithClock(10*time.Second, 10, 100, c)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		qe.AddSample(1000)
+	}
+	c.Advance(5 * time.Second)
+	for i := 0; i < 1000; i++ {
+		qe.AddSample(10)
+	}
+	assert.Equal(t, 2000.0, qe.Count())
+	assert.Equal(t, 1000.0, qe.Quantile(0.9))
+
+	c.Advance(6 * time.Second)
+	assert.Equal(t, 1000.0, qe.Count())
+	assert.Equal(t, 10.0, qe.Quantile(0.9))
+
+	c.Advance(5 * time.Second)
+	assert.Equal(t, 0.0, qe.Count())
+	assert.True(t, math.IsNaN(qe.Quantile(0.9)))
+}
+
+func TestQuantileEstimatorTimeWindowMerge(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	qe1, _ := NewQuantileEstimatorTimeWindowWithClock(10*time.Second, 10, 100, c)
+	qe2, _ := NewQuantileEstimatorTimeWindowWithClock(10*time.Second, 10, 100, c)
+	qe2.AddSample(1)
+	c.Advance(5 * time.Second)
+	qe1.AddSample(2)
+	qe2.AddSample(3)
+	qe1.Merge(&qe2)
+	assert.Equal(t, 3.0, qe1.Count())
+	assert.Equal(t, 2.0, qe2.Count())
+
+	//older samples from qe2 keep their bucket and expire
+	c.Advance(6 * time.Second)
+	assert.Equal(t, 2.0, qe1.Count())
+	assert.Equal(t, 2.0, qe1.Quantile(0))
+	assert.Equal(t, 3.0, qe1.Quantile(1))
+
+	unbounded, _ := NewQuantileEstimator(100)
+	unbounded.Merge(&qe1)
+	assert.Equal(t, 2.0, unbounded.Count())
+}
@@ -0,0 +1,445 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gonum/stat"
+)
+
+//Sample value of a series at a point in time, resulting from a query
+type Sample struct {
+	Labels Labels
+	Value  float64
+}
+
+//Query query in a subset of PromQL, evaluated against a SeriesStore. Supported expressions:
+//  - instant and range vector selectors: http_requests{code=~"5..", method!="GET"}[5m]
+//  - rate, irate, increase and deriv of range vectors
+//  - avg_over_time, min_over_time, max_over_time, sum_over_time, count_over_time and quantile_over_time(q, ..)
+//  - arithmetic (+ - * /) between scalars and vectors. Vectors are matched one-to-one by their labels
+//  - sum, avg, min, max and count aggregations, optionally by(labels)
+//Like in Prometheus, the metric name is dropped from the labels of results of functions and arithmetic
+//Only initialize this with ParseQuery(..)
+type Query struct {
+	//LookbackDelta max age of the point used by instant vector selectors (5 minutes by default)
+	LookbackDelta time.Duration
+	expr          queryNode
+}
+
+//ParseQuery parses a query
+func ParseQuery(query string) (Query, error) {
+	expr, err := parseQueryExpr(query)
+	if err != nil {
+		return Query{}, err
+	}
+	return Query{
+		LookbackDelta: 5 * time.Minute,
+		expr:          expr,
+	}, nil
+}
+
+//Query parses and evaluates 'query' at time 'at'. See Query.Eval(..)
+func (s *SeriesStore) Query(query string, at time.Time) ([]Sample, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Eval(s, at)
+}
+
+//QueryRange parses and evaluates 'query' from 'from' to 'to'. See Query.EvalRange(..)
+func (s *SeriesStore) QueryRange(query string, from time.Time, to time.Time, step time.Duration) ([]Series, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.EvalRange(s, from, to, step)
+}
+
+//Eval evaluates this query at time 'at' (instant query). Results are ordered by labels
+//A scalar result is returned as a single sample without labels
+func (q Query) Eval(store *SeriesStore, at time.Time) ([]Sample, error) {
+	v, err := q.expr.eval(&queryContext{store: store, at: at, lookback: q.LookbackDelta})
+	if err != nil {
+		return nil, err
+	}
+	switch v.typ {
+	case scalarValue:
+		return []Sample{{Labels{}, v.scalar}}, nil
+	case vectorValue:
+		return v.vector, nil
+	}
+	return nil, fmt.Errorf("query must result in a scalar or an instant vector")
+}
+
+//EvalRange evaluates this query at each 'step' from 'from' to 'to' (inclusive), just like a Prometheus range query
+//Returns one series (with Timeseries set) for each labels set in results, ordered by labels
+func (q Query) EvalRange(store *SeriesStore, from time.Time, to time.Time, step time.Duration) ([]Series, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	if to.Before(from) {
+		return nil, fmt.Errorf("'to' must not be before 'from'")
+	}
+	labels := make(map[string]Labels)
+	points := make(map[string][]TimeValue)
+	for at := from; !at.After(to); at = at.Add(step) {
+		samples, err := q.Eval(store, at)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range samples {
+			key := s.Labels.key()
+			labels[key] = s.Labels
+			points[key] = append(points[key], TimeValue{at, s.Value})
+		}
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]Series, len(keys))
+	for i, k := range keys {
+		ts := newDerivedTimeseries(to.Sub(from), store.clock, false, points[k])
+		result[i] = Series{Labels: labels[k], Timeseries: &ts}
+	}
+	return result, nil
+}
+
+type queryValueType int
+
+const (
+	scalarValue queryValueType = iota
+	vectorValue
+	matrixValue
+)
+
+func (t queryValueType) String() string {
+	return []string{"scalar", "instant vector", "range vector"}[t]
+}
+
+type rangeSeries struct {
+	labels Labels
+	points []TimeValue
+}
+
+type queryValue struct {
+	typ    queryValueType
+	scalar float64
+	vector []Sample
+	matrix []rangeSeries
+	//rng range of range vectors
+	rng time.Duration
+}
+
+type queryContext struct {
+	store    *SeriesStore
+	at       time.Time
+	lookback time.Duration
+}
+
+func (n *numberNode) eval(ctx *queryContext) (queryValue, error) {
+	return queryValue{typ: scalarValue, scalar: n.value}, nil
+}
+
+func (n *selectorNode) eval(ctx *queryContext) (queryValue, error) {
+	series := ctx.store.Select(n.matchers...)
+	if n.rng == 0 {
+		v := queryValue{typ: vectorValue, vector: make([]Sample, 0)}
+		for _, se := range series {
+			ps := pointsBetween(se.points(), ctx.at.Add(-ctx.lookback), ctx.at)
+			if len(ps) > 0 {
+				v.vector = append(v.vector, Sample{se.Labels, ps[len(ps)-1].Value})
+			}
+		}
+		return v, nil
+	}
+	v := queryValue{typ: matrixValue, matrix: make([]rangeSeries, 0), rng: n.rng}
+	for _, se := range series {
+		ps := pointsBetween(se.points(), ctx.at.Add(-n.rng), ctx.at)
+		if len(ps) > 0 {
+			v.matrix = append(v.matrix, rangeSeries{se.Labels, ps})
+		}
+	}
+	return v, nil
+}
+
+//pointsBetween points of 'ts' with time in (from, to]
+func pointsBetween(ts *Timeseries, from time.Time, to time.Time) []TimeValue {
+	ps := make([]TimeValue, 0)
+	it := ts.Iterator()
+	for more := it.Seek(from); more && !it.At().Time.After(to); more = it.Next() {
+		if it.At().Time.After(from) {
+			ps = append(ps, it.At())
+		}
+	}
+	return ps
+}
+
+type queryFunction struct {
+	argTypes []queryValueType
+	call     func(ctx *queryContext, args []queryValue) queryValue
+}
+
+var queryFunctions = map[string]queryFunction{
+	"rate": rangeFunction(func(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool) {
+		return extrapolatedDelta(ps, ctx.at.Add(-rng), ctx.at, true, true)
+	}),
+	"increase": rangeFunction(func(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool) {
+		return extrapolatedDelta(ps, ctx.at.Add(-rng), ctx.at, true, false)
+	}),
+	"irate":           rangeFunction(instantRate),
+	"deriv":           rangeFunction(deriv),
+	"avg_over_time":   overTimeFunction(AvgOverTime),
+	"min_over_time":   overTimeFunction(MinOverTime),
+	"max_over_time":   overTimeFunction(MaxOverTime),
+	"sum_over_time":   overTimeFunction(SumOverTime),
+	"count_over_time": overTimeFunction(CountOverTime),
+	"quantile_over_time": {
+		argTypes: []queryValueType{scalarValue, matrixValue},
+		call: func(ctx *queryContext, args []queryValue) queryValue {
+			f := overTimeFunction(QuantileOverTime(args[0].scalar))
+			return f.call(ctx, args[1:])
+		},
+	},
+}
+
+//rangeFunction function of one range vector that calculates a value for each series
+func rangeFunction(f func(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool)) queryFunction {
+	return queryFunction{
+		argTypes: []queryValueType{matrixValue},
+		call: func(ctx *queryContext, args []queryValue) queryValue {
+			v := queryValue{typ: vectorValue, vector: make([]Sample, 0)}
+			for _, rs := range args[0].matrix {
+				if r, ok := f(rs.points, ctx, args[0].rng); ok {
+					v.vector = append(v.vector, Sample{dropMetricName(rs.labels), r})
+				}
+			}
+			return v
+		},
+	}
+}
+
+func overTimeFunction(f OverTimeFunc) queryFunction {
+	return rangeFunction(func(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool) {
+		values := make([]float64, len(ps))
+		for i, p := range ps {
+			values[i] = p.Value
+		}
+		return f(values), true
+	})
+}
+
+//extrapolatedDelta calculates the difference between the first and last points, extrapolated to the whole
+//range (from, to], as Prometheus' rate() and increase() do. For counters, decreases are handled as resets
+//If 'perSecond' the result is divided by the range duration
+func extrapolatedDelta(ps []TimeValue, from time.Time, to time.Time, counter bool, perSecond bool) (float64, bool) {
+	if len(ps) < 2 {
+		return 0, false
+	}
+	first := ps[0]
+	last := ps[len(ps)-1]
+	delta := last.Value - first.Value
+	if counter {
+		for i := 1; i < len(ps); i++ {
+			if ps[i].Value < ps[i-1].Value {
+				delta = delta + ps[i-1].Value
+			}
+		}
+	}
+	sampled := last.Time.Sub(first.Time).Seconds()
+	if sampled <= 0 {
+		return 0, false
+	}
+	toStart := first.Time.Sub(from).Seconds()
+	toEnd := to.Sub(last.Time).Seconds()
+	avgInterval := sampled / float64(len(ps)-1)
+	//counters can't be extrapolated below zero
+	if counter && delta > 0 && first.Value >= 0 {
+		toZero := sampled * (first.Value / delta)
+		if toZero < toStart {
+			toStart = toZero
+		}
+	}
+	//extrapolate to the range boundaries only if they are near the first/last points
+	threshold := avgInterval * 1.1
+	interval := sampled
+	if toStart < threshold {
+		interval = interval + toStart
+	} else {
+		interval = interval + avgInterval/2
+	}
+	if toEnd < threshold {
+		interval = interval + toEnd
+	} else {
+		interval = interval + avgInterval/2
+	}
+	delta = delta * (interval / sampled)
+	if perSecond {
+		delta = delta / to.Sub(from).Seconds()
+	}
+	return delta, true
+}
+
+//instantRate per second rate between the last two points, handling counter resets
+func instantRate(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool) {
+	if len(ps) < 2 {
+		return 0, false
+	}
+	prev := ps[len(ps)-2]
+	last := ps[len(ps)-1]
+	delta := last.Value - prev.Value
+	if last.Value < prev.Value {
+		delta = last.Value
+	}
+	dt := last.Time.Sub(prev.Time).Seconds()
+	if dt <= 0 {
+		return 0, false
+	}
+	return delta / dt, true
+}
+
+//deriv per second derivative using linear regression
+func deriv(ps []TimeValue, ctx *queryContext, rng time.Duration) (float64, bool) {
+	if len(ps) < 2 {
+		return 0, false
+	}
+	x := make([]float64, len(ps))
+	y := make([]float64, len(ps))
+	for i, p := range ps {
+		//relative to query time for numerical stability
+		x[i] = p.Time.Sub(ctx.at).Seconds()
+		y[i] = p.Value
+	}
+	_, beta := stat.LinearRegression(x, y, nil, false)
+	return beta, true
+}
+
+func (n *callNode) eval(ctx *queryContext) (queryValue, error) {
+	f := queryFunctions[n.function]
+	if len(n.args) != len(f.argTypes) {
+		return queryValue{}, fmt.Errorf("function %s expects %d arguments but got %d", n.function, len(f.argTypes), len(n.args))
+	}
+	args := make([]queryValue, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(ctx)
+		if err != nil {
+			return queryValue{}, err
+		}
+		if v.typ != f.argTypes[i] {
+			return queryValue{}, fmt.Errorf("function %s expects a %s as argument %d but got a %s", n.function, f.argTypes[i], i+1, v.typ)
+		}
+		args[i] = v
+	}
+	return f.call(ctx, args), nil
+}
+
+var aggregationFunctions = map[string]OverTimeFunc{
+	"sum":   SumOverTime,
+	"avg":   AvgOverTime,
+	"min":   MinOverTime,
+	"max":   MaxOverTime,
+	"count": CountOverTime,
+}
+
+func (n *aggregationNode) eval(ctx *queryContext) (queryValue, error) {
+	v, err := n.expr.eval(ctx)
+	if err != nil {
+		return queryValue{}, err
+	}
+	if v.typ != vectorValue {
+		return queryValue{}, fmt.Errorf("%s expects an instant vector but got a %s", n.op, v.typ)
+	}
+	groups := make(map[string][]float64)
+	groupLabels := make(map[string]Labels)
+	for _, s := range v.vector {
+		labels := Labels{}
+		for _, l := range n.by {
+			labels[l] = s.Labels[l]
+		}
+		labels = labels.copy()
+		key := labels.key()
+		groups[key] = append(groups[key], s.Value)
+		groupLabels[key] = labels
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := queryValue{typ: vectorValue, vector: make([]Sample, len(keys))}
+	for i, k := range keys {
+		result.vector[i] = Sample{groupLabels[k], aggregationFunctions[n.op](groups[k])}
+	}
+	return result, nil
+}
+
+func (n *binaryNode) eval(ctx *queryContext) (queryValue, error) {
+	lhs, err := n.lhs.eval(ctx)
+	if err != nil {
+		return queryValue{}, err
+	}
+	rhs, err := n.rhs.eval(ctx)
+	if err != nil {
+		return queryValue{}, err
+	}
+	if lhs.typ == matrixValue || rhs.typ == matrixValue {
+		return queryValue{}, fmt.Errorf("arithmetic is not supported for range vectors")
+	}
+	if lhs.typ == scalarValue && rhs.typ == scalarValue {
+		return queryValue{typ: scalarValue, scalar: n.apply(lhs.scalar, rhs.scalar)}, nil
+	}
+	result := queryValue{typ: vectorValue, vector: make([]Sample, 0)}
+	if lhs.typ == vectorValue && rhs.typ == vectorValue {
+		//one-to-one matching by labels without metric name
+		rhsByKey := make(map[string]float64)
+		for _, s := range rhs.vector {
+			key := dropMetricName(s.Labels).key()
+			if _, ok := rhsByKey[key]; ok {
+				return queryValue{}, fmt.Errorf("many-to-many matching is not supported. duplicate series for %s", dropMetricName(s.Labels))
+			}
+			rhsByKey[key] = s.Value
+		}
+		for _, s := range lhs.vector {
+			labels := dropMetricName(s.Labels)
+			if r, ok := rhsByKey[labels.key()]; ok {
+				result.vector = append(result.vector, Sample{labels, n.apply(s.Value, r)})
+			}
+		}
+		return result, nil
+	}
+	if lhs.typ == vectorValue {
+		for _, s := range lhs.vector {
+			result.vector = append(result.vector, Sample{dropMetricName(s.Labels), n.apply(s.Value, rhs.scalar)})
+		}
+		return result, nil
+	}
+	for _, s := range rhs.vector {
+		result.vector = append(result.vector, Sample{dropMetricName(s.Labels), n.apply(lhs.scalar, s.Value)})
+	}
+	return result, nil
+}
+
+func (n *binaryNode) apply(a float64, b float64) float64 {
+	switch n.op {
+	case tokenAdd:
+		return a + b
+	case tokenSub:
+		return a - b
+	case tokenMul:
+		return a * b
+	case tokenDiv:
+		return a / b
+	}
+	return math.NaN()
+}
+
+func dropMetricName(labels Labels) Labels {
+	l := labels.copy()
+	delete(l, MetricNameLabel)
+	return l
+}
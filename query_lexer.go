@@ -0,0 +1,180 @@
+package signalutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type queryTokenType int
+
+const (
+	tokenEOF queryTokenType = iota
+	tokenIdentifier
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenLeftBrace
+	tokenRightBrace
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBracket
+	tokenRightBracket
+	tokenComma
+	tokenEqual
+	tokenNotEqual
+	tokenRegexpEqual
+	tokenRegexpNotEqual
+	tokenAdd
+	tokenSub
+	tokenMul
+	tokenDiv
+)
+
+type queryToken struct {
+	typ queryTokenType
+	//text identifier name, unquoted string or number/duration as written
+	text string
+	pos  int
+}
+
+func (t queryToken) String() string {
+	if t.typ == tokenEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.text)
+}
+
+//lexQuery splits a query in tokens
+func lexQuery(query string) ([]queryToken, error) {
+	tokens := make([]queryToken, 0)
+	symbols := []struct {
+		text string
+		typ  queryTokenType
+	}{
+		//two chars symbols first
+		{"!=", tokenNotEqual}, {"=~", tokenRegexpEqual}, {"!~", tokenRegexpNotEqual},
+		{"{", tokenLeftBrace}, {"}", tokenRightBrace}, {"(", tokenLeftParen}, {")", tokenRightParen},
+		{"[", tokenLeftBracket}, {"]", tokenRightBracket}, {",", tokenComma}, {"=", tokenEqual},
+		{"+", tokenAdd}, {"-", tokenSub}, {"*", tokenMul}, {"/", tokenDiv},
+	}
+	i := 0
+next:
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i = i + 1
+			continue
+		case isIdentifierStart(c):
+			j := i + 1
+			for j < len(query) && (isIdentifierStart(query[j]) || isDigit(query[j])) {
+				j = j + 1
+			}
+			tokens = append(tokens, queryToken{tokenIdentifier, query[i:j], i})
+			i = j
+			continue
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			j := i
+			for j < len(query) && (isDigit(query[j]) || isIdentifierStart(query[j]) || query[j] == '.' ||
+				((query[j] == '+' || query[j] == '-') && (query[j-1] == 'e' || query[j-1] == 'E'))) {
+				j = j + 1
+			}
+			text := query[i:j]
+			if _, err := strconv.ParseFloat(text, 64); err == nil {
+				tokens = append(tokens, queryToken{tokenNumber, text, i})
+			} else if _, err := parseQueryDuration(text); err == nil {
+				tokens = append(tokens, queryToken{tokenDuration, text, i})
+			} else {
+				return nil, fmt.Errorf("invalid number or duration %q at position %d", text, i)
+			}
+			i = j
+			continue
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(query) && query[j] != c {
+				if query[j] == '\\' {
+					j = j + 1
+				}
+				j = j + 1
+			}
+			if j >= len(query) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			raw := query[i+1 : j]
+			if c == '\'' {
+				raw = strings.NewReplacer(`\'`, `'`, `"`, `\"`).Replace(raw)
+			}
+			s, err := strconv.Unquote(`"` + raw + `"`)
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at position %d: %s", i, err)
+			}
+			tokens = append(tokens, queryToken{tokenString, s, i})
+			i = j + 1
+			continue
+		}
+		for _, s := range symbols {
+			if strings.HasPrefix(query[i:], s.text) {
+				tokens = append(tokens, queryToken{s.typ, s.text, i})
+				i = i + len(s.text)
+				continue next
+			}
+		}
+		return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+	}
+	return append(tokens, queryToken{tokenEOF, "", len(query)}), nil
+}
+
+func isIdentifierStart(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+var queryDurationUnits = []struct {
+	unit     string
+	duration time.Duration
+}{
+	//'ms' must be checked before 'm'
+	{"ms", time.Millisecond}, {"s", time.Second}, {"m", time.Minute}, {"h", time.Hour},
+	{"d", 24 * time.Hour}, {"w", 7 * 24 * time.Hour}, {"y", 365 * 24 * time.Hour},
+}
+
+//parseQueryDuration parses Prometheus durations, as in "30s", "5m", "1h30m", "2d" or "1w"
+func parseQueryDuration(text string) (time.Duration, error) {
+	d := time.Duration(0)
+	rest := text
+	for rest != "" {
+		j := 0
+		for j < len(rest) && isDigit(rest[j]) {
+			j = j + 1
+		}
+		if j == 0 {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+		n, err := strconv.ParseInt(rest[:j], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		rest = rest[j:]
+		found := false
+		for _, u := range queryDurationUnits {
+			if strings.HasPrefix(rest, u.unit) && (len(rest) == len(u.unit) || isDigit(rest[len(u.unit)])) {
+				d = d + time.Duration(n)*u.duration
+				rest = rest[len(u.unit):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid duration %q", text)
+		}
+	}
+	if d == 0 {
+		return 0, fmt.Errorf("invalid duration %q", text)
+	}
+	return d, nil
+}
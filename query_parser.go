@@ -0,0 +1,330 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//queryNode node of a parsed query expression
+type queryNode interface {
+	eval(ctx *queryContext) (queryValue, error)
+}
+
+type numberNode struct {
+	value float64
+}
+
+type selectorNode struct {
+	matchers []LabelMatcher
+	//rng range of range vector selectors. Zero for instant vector selectors
+	rng time.Duration
+}
+
+type callNode struct {
+	function string
+	args     []queryNode
+}
+
+type aggregationNode struct {
+	op   string
+	by   []string
+	expr queryNode
+}
+
+type binaryNode struct {
+	op  queryTokenType
+	lhs queryNode
+	rhs queryNode
+}
+
+//queryAggregations supported aggregation operators
+var queryAggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+//parseQueryExpr parses a query with the grammar
+//  expr    = term { ("+" | "-") term }
+//  term    = unary { ("*" | "/") unary }
+//  unary   = "-" unary | primary
+//  primary = number | "(" expr ")" | aggregation | function "(" [ expr { "," expr } ] ")" | selector [ "[" duration "]" ]
+func parseQueryExpr(query string) (queryNode, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	n, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return n, nil
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.pos]
+}
+
+func (p *queryParser) next() queryToken {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos = p.pos + 1
+	}
+	return t
+}
+
+func (p *queryParser) expect(typ queryTokenType, what string) (queryToken, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, fmt.Errorf("expected %s but found %s at position %d", what, t, t.pos)
+	}
+	return t, nil
+}
+
+func (p *queryParser) unexpected() error {
+	t := p.peek()
+	return fmt.Errorf("unexpected %s at position %d", t, t.pos)
+}
+
+func (p *queryParser) parseExpr() (queryNode, error) {
+	lhs, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokenAdd || p.peek().typ == tokenSub {
+		op := p.next().typ
+		rhs, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op, lhs, rhs}
+	}
+	return lhs, nil
+}
+
+func (p *queryParser) parseTerm() (queryNode, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().typ == tokenMul || p.peek().typ == tokenDiv {
+		op := p.next().typ
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = &binaryNode{op, lhs, rhs}
+	}
+	return lhs, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.peek().typ == tokenSub {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if num, ok := n.(*numberNode); ok {
+			return &numberNode{-num.value}, nil
+		}
+		return &binaryNode{tokenSub, &numberNode{0}, n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *queryParser) parsePrimary() (queryNode, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokenNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &numberNode{v}, nil
+	case tokenLeftParen:
+		p.next()
+		n, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokenRightParen, `")"`)
+		return n, err
+	case tokenLeftBrace:
+		return p.parseSelector("")
+	case tokenIdentifier:
+		p.next()
+		switch {
+		case strings.EqualFold(t.text, "inf"):
+			return &numberNode{math.Inf(1)}, nil
+		case strings.EqualFold(t.text, "nan"):
+			return &numberNode{math.NaN()}, nil
+		case queryAggregations[t.text] && (p.peek().typ == tokenLeftParen || p.peek().text == "by"):
+			return p.parseAggregation(t.text)
+		case p.peek().typ == tokenLeftParen:
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.text)
+	}
+	return nil, p.unexpected()
+}
+
+func (p *queryParser) parseCall(name queryToken) (queryNode, error) {
+	if _, ok := queryFunctions[name.text]; !ok {
+		return nil, fmt.Errorf("unknown function %q at position %d", name.text, name.pos)
+	}
+	p.next()
+	args := make([]queryNode, 0)
+	for p.peek().typ != tokenRightParen {
+		if len(args) > 0 {
+			_, err := p.expect(tokenComma, `","`)
+			if err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	return &callNode{name.text, args}, nil
+}
+
+//parseAggregation parses 'op by (labels) (expr)' or 'op (expr) by (labels)'
+func (p *queryParser) parseAggregation(op string) (queryNode, error) {
+	n := &aggregationNode{op: op}
+	var err error
+	if p.peek().text == "by" {
+		n.by, err = p.parseBy()
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = p.expect(tokenLeftParen, `"("`)
+	if err != nil {
+		return nil, err
+	}
+	n.expr, err = p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.expect(tokenRightParen, `")"`)
+	if err != nil {
+		return nil, err
+	}
+	if n.by == nil && p.peek().text == "by" {
+		n.by, err = p.parseBy()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *queryParser) parseBy() ([]string, error) {
+	p.next()
+	_, err := p.expect(tokenLeftParen, `"("`)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]string, 0)
+	for p.peek().typ != tokenRightParen {
+		if len(labels) > 0 {
+			_, err := p.expect(tokenComma, `","`)
+			if err != nil {
+				return nil, err
+			}
+		}
+		t, err := p.expect(tokenIdentifier, "label name")
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, t.text)
+	}
+	p.next()
+	return labels, nil
+}
+
+//parseSelector parses 'name{label="value", ...}[range]', in which name, matchers and range are optional
+func (p *queryParser) parseSelector(name string) (queryNode, error) {
+	n := &selectorNode{matchers: make([]LabelMatcher, 0)}
+	if name != "" {
+		m, _ := NewLabelMatcher(MatchEqual, MetricNameLabel, name)
+		n.matchers = append(n.matchers, m)
+	}
+	if p.peek().typ == tokenLeftBrace {
+		p.next()
+		for count := 0; p.peek().typ != tokenRightBrace; count++ {
+			if count > 0 {
+				_, err := p.expect(tokenComma, `","`)
+				if err != nil {
+					return nil, err
+				}
+				//trailing comma
+				if p.peek().typ == tokenRightBrace {
+					break
+				}
+			}
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			n.matchers = append(n.matchers, m)
+		}
+		p.next()
+	}
+	if len(n.matchers) == 0 {
+		return nil, fmt.Errorf("selector must have a metric name or at least one label matcher at position %d", p.peek().pos)
+	}
+	if p.peek().typ == tokenLeftBracket {
+		p.next()
+		t, err := p.expect(tokenDuration, "duration")
+		if err != nil {
+			return nil, err
+		}
+		n.rng, _ = parseQueryDuration(t.text)
+		_, err = p.expect(tokenRightBracket, `"]"`)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return n, nil
+}
+
+func (p *queryParser) parseMatcher() (LabelMatcher, error) {
+	name, err := p.expect(tokenIdentifier, "label name")
+	if err != nil {
+		return LabelMatcher{}, err
+	}
+	types := map[queryTokenType]MatchType{
+		tokenEqual:          MatchEqual,
+		tokenNotEqual:       MatchNotEqual,
+		tokenRegexpEqual:    MatchRegexp,
+		tokenRegexpNotEqual: MatchNotRegexp,
+	}
+	op := p.next()
+	matchType, ok := types[op.typ]
+	if !ok {
+		return LabelMatcher{}, fmt.Errorf("expected label matching operator but found %s at position %d", op, op.pos)
+	}
+	value, err := p.expect(tokenString, "label value")
+	if err != nil {
+		return LabelMatcher{}, err
+	}
+	m, err := NewLabelMatcher(matchType, name.text, value.text)
+	if err != nil {
+		return LabelMatcher{}, fmt.Errorf("invalid matcher at position %d: %s", name.pos, err)
+	}
+	return m, nil
+}
@@ -0,0 +1,118 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryLexer(t *testing.T) {
+	tokens, err := lexQuery(`sum by (code) (rate(http_requests{code=~"5..", method!='GET'}[1h30m])) / 2.5e1`)
+	assert.Nil(t, err)
+	types := make([]queryTokenType, 0)
+	for _, tk := range tokens {
+		types = append(types, tk.typ)
+	}
+	assert.Equal(t, []queryTokenType{
+		tokenIdentifier, tokenIdentifier, tokenLeftParen, tokenIdentifier, tokenRightParen,
+		tokenLeftParen, tokenIdentifier, tokenLeftParen, tokenIdentifier, tokenLeftBrace,
+		tokenIdentifier, tokenRegexpEqual, tokenString, tokenComma, tokenIdentifier, tokenNotEqual, tokenString,
+		tokenRightBrace, tokenLeftBracket, tokenDuration, tokenRightBracket, tokenRightParen, tokenRightParen,
+		tokenDiv, tokenNumber, tokenEOF,
+	}, types)
+	assert.Equal(t, "5..", tokens[12].text)
+	assert.Equal(t, "GET", tokens[16].text)
+	assert.Equal(t, "1h30m", tokens[19].text)
+
+	_, err = lexQuery(`foo{a="b}`)
+	assert.NotNil(t, err)
+	_, err = lexQuery(`foo # bar`)
+	assert.NotNil(t, err)
+}
+
+func TestQueryDuration(t *testing.T) {
+	d, err := parseQueryDuration("1h30m")
+	assert.Nil(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+	d, _ = parseQueryDuration("500ms")
+	assert.Equal(t, 500*time.Millisecond, d)
+	d, _ = parseQueryDuration("2d")
+	assert.Equal(t, 48*time.Hour, d)
+	d, _ = parseQueryDuration("1w")
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	_, err = parseQueryDuration("5x")
+	assert.NotNil(t, err)
+	_, err = parseQueryDuration("m")
+	assert.NotNil(t, err)
+	_, err = parseQueryDuration("0s")
+	assert.NotNil(t, err)
+}
+
+func TestQueryParser(t *testing.T) {
+	n, err := parseQueryExpr(`http_requests{code=~"5..", method!="GET",}[5m]`)
+	assert.Nil(t, err)
+	s := n.(*selectorNode)
+	assert.Equal(t, 5*time.Minute, s.rng)
+	assert.Equal(t, 3, len(s.matchers))
+	assert.Equal(t, MetricNameLabel, s.matchers[0].Name)
+	assert.Equal(t, MatchRegexp, s.matchers[1].Type)
+	assert.Equal(t, MatchNotEqual, s.matchers[2].Type)
+
+	n, err = parseQueryExpr(`sum by (code, method) (rate({job="api"}[1m]))`)
+	assert.Nil(t, err)
+	a := n.(*aggregationNode)
+	assert.Equal(t, "sum", a.op)
+	assert.Equal(t, []string{"code", "method"}, a.by)
+	assert.Equal(t, "rate", a.expr.(*callNode).function)
+
+	n, err = parseQueryExpr(`avg(foo) by (code)`)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"code"}, n.(*aggregationNode).by)
+
+	//operator precedence
+	n, err = parseQueryExpr(`1 + 2 * -3`)
+	assert.Nil(t, err)
+	b := n.(*binaryNode)
+	assert.Equal(t, tokenAdd, b.op)
+	assert.Equal(t, tokenMul, b.rhs.(*binaryNode).op)
+	assert.Equal(t, -3.0, b.rhs.(*binaryNode).rhs.(*numberNode).value)
+
+	n, err = parseQueryExpr(`(1 + 2) * 3`)
+	assert.Nil(t, err)
+	assert.Equal(t, tokenMul, n.(*binaryNode).op)
+
+	n, err = parseQueryExpr(`-Inf`)
+	assert.Nil(t, err)
+	assert.True(t, math.IsInf(n.(*numberNode).value, -1))
+
+	//metric names may be aggregation names
+	n, err = parseQueryExpr(`count`)
+	assert.Nil(t, err)
+	assert.Equal(t, "count", n.(*selectorNode).matchers[0].Value)
+}
+
+func TestQueryParserErrors(t *testing.T) {
+	for _, q := range []string{
+		``,
+		`sum(`,
+		`foo{a=}`,
+		`foo{a="b" c="d"}`,
+		`foo{a~"b"}`,
+		`foo{a=~"("}`,
+		`foo[5x]`,
+		`foo[5m`,
+		`{}`,
+		`unknown(foo)`,
+		`rate(foo[1m]`,
+		`1 +`,
+		`foo bar`,
+		`)`,
+		`sum by (1) (foo)`,
+	} {
+		_, err := parseQueryExpr(q)
+		assert.NotNil(t, err, q)
+	}
+}
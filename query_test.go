@@ -0,0 +1,231 @@
+package signalutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newQueryTestStore has points every 10s from 0s to 60s
+func newQueryTestStore() (SeriesStore, time.Time) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	s := NewSeriesStoreWithClock(1*time.Hour, c)
+	r200, _ := s.Timeseries(Labels{MetricNameLabel: "http_requests", "code": "200", "path": "/a"})
+	r500, _ := s.Timeseries(Labels{MetricNameLabel: "http_requests", "code": "500", "path": "/a"})
+	rb, _ := s.Timeseries(Labels{MetricNameLabel: "http_requests", "code": "200", "path": "/b"})
+	temp, _ := s.Timeseries(Labels{MetricNameLabel: "temperature", "room": "kitchen"})
+	for i := 0; i <= 6; i++ {
+		when := start.Add(time.Duration(i) * 10 * time.Second)
+		r200.AddWithTime(float64(i*10), when)
+		r500.AddWithTime(float64(i), when)
+		rb.AddWithTime(float64(i*20), when)
+		temp.AddWithTime(20+float64(i)*0.5, when)
+	}
+	return s, start
+}
+
+func sampleValues(samples []Sample) []float64 {
+	vs := make([]float64, 0)
+	for _, s := range samples {
+		vs = append(vs, s.Value)
+	}
+	return vs
+}
+
+func TestQuerySelector(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+
+	r, err := s.Query(`http_requests{code="200"}`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{60, 120}, sampleValues(r))
+	assert.Equal(t, Labels{MetricNameLabel: "http_requests", "code": "200", "path": "/a"}, r[0].Labels)
+
+	//last point before query time
+	r, _ = s.Query(`http_requests{code="500"}`, start.Add(25*time.Second))
+	assert.Equal(t, []float64{2}, sampleValues(r))
+
+	//lookback delta
+	r, _ = s.Query(`temperature`, start.Add(5*time.Minute+59*time.Second))
+	assert.Equal(t, []float64{23}, sampleValues(r))
+	r, _ = s.Query(`temperature`, start.Add(6*time.Minute))
+	assert.Equal(t, 0, len(r))
+
+	q, _ := ParseQuery(`temperature`)
+	q.LookbackDelta = 10 * time.Minute
+	r, _ = q.Eval(&s, start.Add(6*time.Minute+1*time.Second))
+	assert.Equal(t, 1, len(r))
+
+	r, _ = s.Query(`{path=~"/.*", code!="200"}`, at)
+	assert.Equal(t, []float64{6}, sampleValues(r))
+	r, _ = s.Query(`unknown_metric`, at)
+	assert.Equal(t, 0, len(r))
+}
+
+func TestQueryRate(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+
+	r, err := s.Query(`rate(http_requests[1m])`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(r))
+	assert.InDelta(t, 1.0, r[0].Value, 1e-9)
+	assert.InDelta(t, 2.0, r[1].Value, 1e-9)
+	assert.InDelta(t, 0.1, r[2].Value, 1e-9)
+	//functions drop metric name
+	assert.Equal(t, Labels{"code": "200", "path": "/a"}, r[0].Labels)
+
+	r, _ = s.Query(`increase(http_requests{code="500"}[1m])`, at)
+	assert.InDelta(t, 6.0, r[0].Value, 1e-9)
+
+	r, _ = s.Query(`irate(http_requests{code="200"}[1m])`, at)
+	assert.Equal(t, []float64{1, 2}, sampleValues(r))
+
+	r, _ = s.Query(`deriv(temperature[1m])`, at)
+	assert.InDelta(t, 0.05, r[0].Value, 1e-9)
+
+	//not enough points
+	r, _ = s.Query(`rate(http_requests[5s])`, at)
+	assert.Equal(t, 0, len(r))
+}
+
+func TestQueryRateCounterReset(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	s := NewSeriesStoreWithClock(1*time.Hour, c)
+	ts, _ := s.Timeseries(Labels{MetricNameLabel: "requests"})
+	for i, v := range []float64{0, 10, 20, 5, 15} {
+		ts.AddWithTime(v, start.Add(time.Duration(i)*10*time.Second))
+	}
+	at := start.Add(40 * time.Second)
+
+	r, err := s.Query(`increase(requests[1m])`, at)
+	assert.Nil(t, err)
+	assert.InDelta(t, 35.0, r[0].Value, 1e-9)
+
+	r, _ = s.Query(`irate(requests[1m])`, start.Add(30*time.Second))
+	assert.InDelta(t, 0.5, r[0].Value, 1e-9)
+}
+
+func TestQueryOverTime(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+
+	r, err := s.Query(`avg_over_time(temperature[30s])`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{22.5}, sampleValues(r))
+	r, _ = s.Query(`max_over_time(temperature[30s])`, at)
+	assert.Equal(t, []float64{23}, sampleValues(r))
+	r, _ = s.Query(`min_over_time(temperature[30s])`, at)
+	assert.Equal(t, []float64{22}, sampleValues(r))
+	r, _ = s.Query(`sum_over_time(temperature[30s])`, at)
+	assert.Equal(t, []float64{67.5}, sampleValues(r))
+	r, _ = s.Query(`count_over_time(temperature[30s])`, at)
+	assert.Equal(t, []float64{3}, sampleValues(r))
+	r, _ = s.Query(`quantile_over_time(0.5, temperature[30s])`, at)
+	assert.Equal(t, []float64{22.5}, sampleValues(r))
+}
+
+func TestQueryArithmetic(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+
+	r, err := s.Query(`1 + 2 * 3`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, []Sample{{Labels{}, 7}}, r)
+
+	r, _ = s.Query(`temperature * 2 - 1`, at)
+	assert.Equal(t, []float64{45}, sampleValues(r))
+	assert.Equal(t, Labels{"room": "kitchen"}, r[0].Labels)
+	r, _ = s.Query(`100 / http_requests{code="500"}`, at)
+	assert.InDelta(t, 16.67, r[0].Value, 0.01)
+	r, _ = s.Query(`-temperature`, at)
+	assert.Equal(t, []float64{-23}, sampleValues(r))
+
+	//one-to-one matching by labels
+	r, _ = s.Query(`http_requests{code="200"} / http_requests{path="/a"}`, at)
+	assert.Equal(t, []float64{1}, sampleValues(r))
+	r, _ = s.Query(`rate(http_requests[1m]) * 60 - increase(http_requests[1m])`, at)
+	assert.Equal(t, 3, len(r))
+	for _, v := range r {
+		assert.InDelta(t, 0.0, v.Value, 1e-9)
+	}
+
+	//series without matching labels are skipped
+	r, err = s.Query(`temperature + {code="500"}`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(r))
+
+	//duplicate series after dropping metric name
+	ts, _ := s.Timeseries(Labels{MetricNameLabel: "errors", "code": "500", "path": "/a"})
+	ts.AddWithTime(1, at)
+	_, err = s.Query(`temperature + {code="500"}`, at)
+	assert.NotNil(t, err)
+}
+
+func TestQueryAggregation(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+
+	r, err := s.Query(`sum(rate(http_requests[1m]))`, at)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(r))
+	assert.InDelta(t, 3.1, r[0].Value, 1e-9)
+	assert.Equal(t, Labels{}, r[0].Labels)
+
+	r, _ = s.Query(`sum by (code) (http_requests)`, at)
+	assert.Equal(t, []float64{180, 6}, sampleValues(r))
+	assert.Equal(t, Labels{"code": "200"}, r[0].Labels)
+	assert.Equal(t, Labels{"code": "500"}, r[1].Labels)
+
+	r, _ = s.Query(`avg(http_requests) by (path)`, at)
+	assert.Equal(t, []float64{33, 120}, sampleValues(r))
+	r, _ = s.Query(`max(http_requests)`, at)
+	assert.Equal(t, []float64{120}, sampleValues(r))
+	r, _ = s.Query(`min(http_requests)`, at)
+	assert.Equal(t, []float64{6}, sampleValues(r))
+	r, _ = s.Query(`count by (path) (http_requests)`, at)
+	assert.Equal(t, []float64{2, 1}, sampleValues(r))
+	r, _ = s.Query(`sum(unknown_metric)`, at)
+	assert.Equal(t, 0, len(r))
+}
+
+func TestQueryRange(t *testing.T) {
+	s, start := newQueryTestStore()
+
+	r, err := s.QueryRange(`sum(http_requests{path="/a"})`, start, start.Add(30*time.Second), 5*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, []float64{0, 0, 11, 11, 22, 22, 33}, tsValues(*r[0].Timeseries))
+	assert.Equal(t, start.Add(5*time.Second), r[0].Timeseries.Values[1].Time)
+
+	r, _ = s.QueryRange(`irate(http_requests[1m])`, start, start.Add(60*time.Second), 10*time.Second)
+	assert.Equal(t, 3, len(r))
+	assert.Equal(t, Labels{"code": "200", "path": "/a"}, r[0].Labels)
+	assert.Equal(t, []float64{1, 1, 1, 1, 1, 1}, tsValues(*r[0].Timeseries))
+
+	_, err = s.QueryRange(`http_requests`, start, start.Add(1*time.Second), 0)
+	assert.NotNil(t, err)
+	_, err = s.QueryRange(`http_requests`, start.Add(1*time.Second), start, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestQueryErrors(t *testing.T) {
+	s, start := newQueryTestStore()
+	at := start.Add(60 * time.Second)
+	for _, q := range []string{
+		`http_requests[1m]`,
+		`rate(http_requests)`,
+		`rate(http_requests[1m], 1)`,
+		`quantile_over_time(http_requests[1m], 0.5)`,
+		`http_requests + http_requests[1m]`,
+		`sum(http_requests[1m])`,
+		`sum(1)`,
+		`foo{`,
+	} {
+		_, err := s.Query(q, at)
+		assert.NotNil(t, err, q)
+	}
+}
@@ -12,6 +12,7 @@ type SchmittTrigger struct {
 	LowerLimit float64
 	UpperLimit float64
 	UpperRange bool
+	filter     Averager
 	m          *sync.Mutex
 }
 
@@ -29,9 +30,17 @@ func NewSchmittTrigger(lowerLimit float64, upperLimit float64, upperRange bool)
 }
 
 //SetCurrentValue set current value and calculate if it is in upper or lower range
+//If an input filter is set, the filtered value is used instead
 func (s *SchmittTrigger) SetCurrentValue(value float64) {
 	s.m.Lock()
 	defer s.m.Unlock()
+	if s.filter != nil {
+		s.filter.AddSample(value)
+		value = s.filter.Average()
+		if math.IsNaN(value) {
+			return
+		}
+	}
 	if s.UpperRange {
 		if value < s.LowerLimit {
 			s.UpperRange = false
@@ -43,6 +52,14 @@ func (s *SchmittTrigger) SetCurrentValue(value float64) {
 	}
 }
 
+//SetInputFilter sets a filter (MedianFilter, TrimmedMean, MovingAverage etc) that will be used to
+//smooth values before they are evaluated by the trigger. nil disables filtering
+func (s *SchmittTrigger) SetInputFilter(filter Averager) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.filter = filter
+}
+
 //IsUpperRange returns whatever it is in upper range or not
 func (s *SchmittTrigger) IsUpperRange() bool {
 	s.m.Lock()
@@ -32,3 +32,19 @@ func TestSchmittTrigger1(t *testing.T) {
 	st.SetCurrentValue(-333)
 	assert.False(t, st.IsUpperRange())
 }
+
+func TestSchmittTriggerInputFilter(t *testing.T) {
+	st, _ := NewSchmittTrigger(10, 20, false)
+	mf, _ := NewMedianFilter(3)
+	st.SetInputFilter(&mf)
+	st.SetCurrentValue(5)
+	st.SetCurrentValue(5)
+	st.SetCurrentValue(100) //spike is ignored
+	assert.False(t, st.IsUpperRange())
+	st.SetCurrentValue(25)
+	assert.True(t, st.IsUpperRange())
+	st.SetCurrentValue(-100) //spike is ignored
+	assert.True(t, st.IsUpperRange())
+	st.SetCurrentValue(0)
+	assert.False(t, st.IsUpperRange())
+}
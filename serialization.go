@@ -0,0 +1,340 @@
+package signalutils
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//serializationVersion first byte of binary encoded states, so that format changes can be detected
+const serializationVersion = 1
+
+//jsonFloat float64 that is encoded in JSON as a string when it is NaN or infinite, just like Prometheus does
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return json.Marshal(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return json.Marshal(v)
+}
+
+func (f *jsonFloat) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*f = jsonFloat(v)
+		return nil
+	}
+	var v float64
+	err := json.Unmarshal(data, &v)
+	*f = jsonFloat(v)
+	return err
+}
+
+func marshalBinaryState(state interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{serializationVersion})
+	err := gob.NewEncoder(buf).Encode(state)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unmarshalBinaryState(data []byte, state interface{}) error {
+	if len(data) == 0 || data[0] != serializationVersion {
+		return fmt.Errorf("unsupported serialization format")
+	}
+	return gob.NewDecoder(bytes.NewReader(data[1:])).Decode(state)
+}
+
+type timeValueState struct {
+	Time  time.Time `json:"t"`
+	Value jsonFloat `json:"v"`
+}
+
+type timeseriesState struct {
+	Span            time.Duration    `json:"span"`
+	Compressed      bool             `json:"compressed"`
+	InsertPolicy    InsertPolicy     `json:"insertPolicy"`
+	RetentionPolicy RetentionPolicy  `json:"retentionPolicy"`
+	Dropped         int64            `json:"dropped"`
+	Points          []timeValueState `json:"points"`
+}
+
+//MarshalBinary encodes all points and configuration of this timeseries (see encoding.BinaryMarshaler)
+func (t *Timeseries) MarshalBinary() ([]byte, error) {
+	return marshalBinaryState(t.state())
+}
+
+//UnmarshalBinary restores a timeseries encoded with MarshalBinary(). The clock of this timeseries is kept
+//(SystemClock for a zero value Timeseries). Points older than the retention are only pruned on the next addition
+//Returns an error if a WAL is attached to this timeseries, as the WAL wouldn't contain the restored points
+func (t *Timeseries) UnmarshalBinary(data []byte) error {
+	state := timeseriesState{}
+	err := unmarshalBinaryState(data, &state)
+	if err != nil {
+		return err
+	}
+	return t.restore(state)
+}
+
+//MarshalJSON encodes all points and configuration of this timeseries as JSON (see json.Marshaler)
+func (t *Timeseries) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.state())
+}
+
+//UnmarshalJSON same as UnmarshalBinary(..), but for JSON encoded with MarshalJSON()
+func (t *Timeseries) UnmarshalJSON(data []byte) error {
+	state := timeseriesState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	return t.restore(state)
+}
+
+func (t *Timeseries) state() timeseriesState {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.currentState()
+}
+
+//currentState same as state(), for callers already holding the lock
+func (t *Timeseries) currentState() timeseriesState {
+	ps := t.points(0, t.size())
+	state := timeseriesState{
+		Span:            t.TimeseriesSpan,
+		Compressed:      t.chunks != nil,
+		InsertPolicy:    t.insertPolicy,
+		RetentionPolicy: t.retentionPolicy,
+		Dropped:         t.dropped,
+		Points:          make([]timeValueState, len(ps)),
+	}
+	for i, p := range ps {
+		state.Points[i] = timeValueState{p.Time, jsonFloat(p.Value)}
+	}
+	return state
+}
+
+func (t *Timeseries) restore(state timeseriesState) error {
+	clock := t.clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	r := NewTimeseriesWithClock(state.Span, clock)
+	if state.Compressed {
+		r = NewCompressedTimeseriesWithClock(state.Span, clock)
+	}
+	r.insertPolicy = state.InsertPolicy
+	r.retentionPolicy = state.RetentionPolicy
+	r.dropped = state.Dropped
+	for i, p := range state.Points {
+		if i > 0 && !state.Points[i-1].Time.Before(p.Time) {
+			return fmt.Errorf("points must be ordered by time. point=%d", i)
+		}
+		r.insert(i, TimeValue{p.Time, float64(p.Value)})
+	}
+	if t.m == nil {
+		t.m = &sync.RWMutex{}
+	}
+	mu := t.m
+	return restoreLocked(mu, func() error {
+		if t.wal != nil {
+			return fmt.Errorf("cannot restore a timeseries with an attached WAL")
+		}
+		r.m = mu
+		*t = r
+		return nil
+	})
+}
+
+//restoreLocked calls 'replace' holding 'mu', the mutex of the value being restored, so that concurrent
+//users never see it half restored. 'replace' must keep 'mu' in the restored value, as others may be waiting on it
+func restoreLocked(mu sync.Locker, replace func() error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	return replace()
+}
+
+type timeseriesCounterRateState struct {
+	Timeseries timeseriesState `json:"timeseries"`
+	Counter    jsonFloat       `json:"counter"`
+	Strict     bool            `json:"strict"`
+}
+
+//MarshalBinary encodes the counter timeseries (see encoding.BinaryMarshaler)
+func (t *TimeseriesCounterRate) MarshalBinary() ([]byte, error) {
+	return marshalBinaryState(t.state())
+}
+
+//UnmarshalBinary restores a counter timeseries encoded with MarshalBinary(). See Timeseries.UnmarshalBinary(..)
+func (t *TimeseriesCounterRate) UnmarshalBinary(data []byte) error {
+	state := timeseriesCounterRateState{}
+	err := unmarshalBinaryState(data, &state)
+	if err != nil {
+		return err
+	}
+	return t.restore(state)
+}
+
+//MarshalJSON encodes the counter timeseries as JSON (see json.Marshaler)
+func (t *TimeseriesCounterRate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.state())
+}
+
+//UnmarshalJSON same as UnmarshalBinary(..), but for JSON encoded with MarshalJSON()
+func (t *TimeseriesCounterRate) UnmarshalJSON(data []byte) error {
+	state := timeseriesCounterRateState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	return t.restore(state)
+}
+
+func (t *TimeseriesCounterRate) state() timeseriesCounterRateState {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.currentState(t.Timeseries.state())
+}
+
+//currentState same as state(), for callers already holding the lock
+func (t *TimeseriesCounterRate) currentState(ts timeseriesState) timeseriesCounterRateState {
+	return timeseriesCounterRateState{
+		Timeseries: ts,
+		Counter:    jsonFloat(t.ccounter),
+		Strict:     t.strict,
+	}
+}
+
+func (t *TimeseriesCounterRate) restore(state timeseriesCounterRateState) error {
+	if t.m == nil {
+		t.m = &sync.RWMutex{}
+	}
+	t.m.Lock()
+	defer t.m.Unlock()
+	err := t.Timeseries.restore(state.Timeseries)
+	if err != nil {
+		return err
+	}
+	t.ccounter = float64(state.Counter)
+	t.strict = state.Strict
+	return nil
+}
+
+type movingAverageState struct {
+	MaxSamples int `json:"maxSamples"`
+	//TimeWindow zero for fixed size averagers
+	TimeWindow     time.Duration `json:"timeWindow"`
+	Samples        []jsonFloat   `json:"samples"`
+	SampleTimes    []time.Time   `json:"sampleTimes,omitempty"`
+	LastSampleTime time.Time     `json:"lastSampleTime"`
+	Rejected       int64         `json:"rejected"`
+}
+
+//MarshalBinary encodes current samples and window configuration of this averager (see encoding.BinaryMarshaler)
+func (m *MovingAverage) MarshalBinary() ([]byte, error) {
+	return marshalBinaryState(m.state())
+}
+
+//UnmarshalBinary restores an averager encoded with MarshalBinary(). The clock of this averager is kept
+//(SystemClock for a zero value MovingAverage). Samples older than the time window are expired on next use
+func (m *MovingAverage) UnmarshalBinary(data []byte) error {
+	state := movingAverageState{}
+	err := unmarshalBinaryState(data, &state)
+	if err != nil {
+		return err
+	}
+	return m.restore(state)
+}
+
+//MarshalJSON encodes current samples and window configuration of this averager as JSON (see json.Marshaler)
+func (m *MovingAverage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.state())
+}
+
+//UnmarshalJSON same as UnmarshalBinary(..), but for JSON encoded with MarshalJSON()
+func (m *MovingAverage) UnmarshalJSON(data []byte) error {
+	state := movingAverageState{}
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return err
+	}
+	return m.restore(state)
+}
+
+func (m *MovingAverage) state() movingAverageState {
+	m.m.Lock()
+	defer m.m.Unlock()
+	state := movingAverageState{
+		MaxSamples: len(m.Samples),
+		Samples:    make([]jsonFloat, m.Size),
+		Rejected:   m.rejected,
+	}
+	for i, v := range m.values() {
+		state.Samples[i] = jsonFloat(v)
+	}
+	if m.samplesDurationNano != -1 {
+		state.TimeWindow = time.Duration(m.samplesDurationNano)
+		state.LastSampleTime = time.Unix(0, m.lastSampleTimeUnixNano)
+		state.SampleTimes = make([]time.Time, m.Size)
+		for i := range state.SampleTimes {
+			state.SampleTimes[i] = time.Unix(0, m.samplesTimeUnixNano[(m.head+i)%len(m.Samples)])
+		}
+	}
+	return state
+}
+
+func (m *MovingAverage) restore(state movingAverageState) error {
+	if len(state.Samples) > state.MaxSamples {
+		return fmt.Errorf("there are more samples than maxSamples")
+	}
+	if state.TimeWindow > 0 && state.MaxSamples < 1 {
+		return fmt.Errorf("maxSamples must be positive for time window averagers")
+	}
+	if state.TimeWindow > 0 && len(state.SampleTimes) != len(state.Samples) {
+		return fmt.Errorf("there must be one sample time for each sample")
+	}
+	clock := m.clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	r := NewMovingAverage(state.MaxSamples)
+	r.clock = clock
+	if state.TimeWindow > 0 {
+		r = NewMovingAverageTimeWindowWithClock(state.TimeWindow, state.MaxSamples, clock)
+		if !state.LastSampleTime.IsZero() {
+			r.lastSampleTimeUnixNano = state.LastSampleTime.UnixNano()
+		}
+	}
+	r.rejected = state.Rejected
+	for i, v := range state.Samples {
+		now := int64(0)
+		if state.TimeWindow > 0 {
+			now = state.SampleTimes[i].UnixNano()
+		}
+		r.push(float64(v), now)
+	}
+	if m.m == nil {
+		m.m = &sync.Mutex{}
+	}
+	mu := m.m
+	return restoreLocked(mu, func() error {
+		r.m = mu
+		if m.addM != nil {
+			r.addM = m.addM
+		}
+		*m = r
+		return nil
+	})
+}
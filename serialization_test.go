@@ -0,0 +1,141 @@
+package signalutils
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimeseriesSerialization(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		start := c.Now()
+		ts := NewTimeseriesWithClock(1*time.Hour, c)
+		if compressed {
+			ts = NewCompressedTimeseriesWithClock(1*time.Hour, c)
+		}
+		ts.SetInsertPolicy(InsertPolicy{Duplicate: DuplicateSum})
+		ts.SetRetentionPolicy(RetentionPolicy{Reference: RetentionLastSample, MaxPoints: 100})
+		ts.AddWithTime(1, start)
+		ts.AddWithTime(math.Inf(1), start.Add(1*time.Second))
+		ts.AddWithTime(3.5, start.Add(2500*time.Millisecond))
+		ts.AddWithTime(2, start.Add(-1*time.Second))
+
+		b, err := ts.MarshalBinary()
+		assert.Nil(t, err)
+		rb := Timeseries{}
+		assert.Nil(t, rb.UnmarshalBinary(b))
+
+		j, err := json.Marshal(&ts)
+		assert.Nil(t, err)
+		assert.Contains(t, string(j), `"v":"+Inf"`)
+		rj := NewTimeseriesWithClock(1*time.Second, c)
+		assert.Nil(t, json.Unmarshal(j, &rj))
+
+		for _, r := range []Timeseries{rb, rj} {
+			assert.Equal(t, 1*time.Hour, r.TimeseriesSpan)
+			assert.Equal(t, compressed, r.chunks != nil)
+			assert.Equal(t, int64(1), r.Dropped())
+			assert.Equal(t, 100, r.retentionPolicy.MaxPoints)
+			vs, _ := r.ValuesRange(start, start.Add(1*time.Minute))
+			assert.Equal(t, 3, len(vs))
+			assert.True(t, vs[2].Time.Equal(start.Add(2500*time.Millisecond)))
+			assert.True(t, math.IsInf(vs[1].Value, 1))
+			//policies are restored
+			assert.Nil(t, r.AddWithTime(1, start.Add(2500*time.Millisecond)))
+			l, _ := r.Last()
+			assert.Equal(t, 4.5, l.Value)
+		}
+		assert.Equal(t, c, rj.clock)
+		assert.Equal(t, SystemClock, rb.clock)
+	}
+
+	ts := Timeseries{}
+	assert.NotNil(t, ts.UnmarshalBinary([]byte{99}))
+	assert.NotNil(t, ts.UnmarshalJSON([]byte(`{"span":1,"points":[{"t":"2020-01-01T00:00:01Z","v":1},{"t":"2020-01-01T00:00:00Z","v":1}]}`)))
+}
+
+func TestTimeseriesCounterRateSerialization(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tc := NewTimeseriesCounterRateWithClock(1*time.Hour, c)
+	tc.Inc(10)
+	c.Advance(1 * time.Second)
+	tc.Inc(20)
+	tc.SetStrict(true)
+
+	for _, codec := range []string{"binary", "json"} {
+		r := TimeseriesCounterRate{}
+		if codec == "binary" {
+			b, err := tc.MarshalBinary()
+			assert.Nil(t, err)
+			assert.Nil(t, r.UnmarshalBinary(b))
+		} else {
+			j, err := json.Marshal(&tc)
+			assert.Nil(t, err)
+			assert.Nil(t, json.Unmarshal(j, &r))
+		}
+		rate, ok := r.Rate(1 * time.Second)
+		assert.True(t, ok)
+		assert.Equal(t, 20.0, rate)
+		assert.Nil(t, r.Inc(5))
+		l, _ := r.Timeseries.Last()
+		assert.Equal(t, 35.0, l.Value)
+		assert.NotNil(t, r.Set(1))
+	}
+}
+
+func TestMovingAverageSerialization(t *testing.T) {
+	ma := NewMovingAverage(3)
+	ma.AddSample(1)
+	ma.AddSample(2)
+	ma.AddSample(3)
+	ma.AddSample(4)
+	ma.AddSampleIfNearAverage(1000, 0.5)
+
+	b, err := ma.MarshalBinary()
+	assert.Nil(t, err)
+	rb := MovingAverage{}
+	assert.Nil(t, rb.UnmarshalBinary(b))
+	j, err := json.Marshal(&ma)
+	assert.Nil(t, err)
+	rj := MovingAverage{}
+	assert.Nil(t, json.Unmarshal(j, &rj))
+
+	for _, r := range []MovingAverage{rb, rj} {
+		assert.Equal(t, 3.0, r.Average())
+		assert.Equal(t, 2.0, r.Min())
+		assert.Equal(t, 4.0, r.Max())
+		assert.Equal(t, 1.0, r.Variance())
+		assert.Equal(t, int64(1), r.Rejected())
+		r.AddSample(5)
+		assert.Equal(t, 4.0, r.Average())
+	}
+
+	assert.NotNil(t, rb.UnmarshalJSON([]byte(`{"maxSamples":1,"samples":[1,2]}`)))
+}
+
+func TestMovingAverageTimeWindowSerialization(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ma := NewMovingAverageTimeWindowWithClock(1*time.Second, 10, c)
+	ma.AddSample(1000)
+	c.Advance(600 * time.Millisecond)
+	ma.AddSample(3000)
+	c.Advance(10 * time.Millisecond)
+
+	j, err := json.Marshal(&ma)
+	assert.Nil(t, err)
+	r := NewMovingAverageTimeWindowWithClock(1*time.Second, 1, c)
+	assert.Nil(t, json.Unmarshal(j, &r))
+	assert.Equal(t, 2000.0, r.Average())
+	//too soon after the last sample
+	assert.False(t, r.AddSample(5000))
+
+	//first sample expires
+	c.Advance(500 * time.Millisecond)
+	assert.Equal(t, 3000.0, r.Average())
+	assert.True(t, r.AddSample(5000))
+	assert.Equal(t, 4000.0, r.Average())
+}
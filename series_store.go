@@ -0,0 +1,213 @@
+package signalutils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+//Series a series in a SeriesStore. Either Timeseries (for gauges) or Counter is set
+type Series struct {
+	Labels     Labels
+	Timeseries *Timeseries
+	Counter    *TimeseriesCounterRate
+}
+
+//points timeseries with the points of this series
+func (s *Series) points() *Timeseries {
+	if s.Counter != nil {
+		return &s.Counter.Timeseries
+	}
+	return s.Timeseries
+}
+
+//SeriesStore registry of many Timeseries and TimeseriesCounterRate identified by labels, all with the same retention
+//Only initialize this with NewSeriesStore(..)
+type SeriesStore struct {
+	TimeseriesSpan  time.Duration
+	retentionPolicy RetentionPolicy
+	series          map[string]*Series
+	clock           Clock
+	m               *sync.RWMutex
+}
+
+//NewSeriesStore creates a new store in which all series keep points for 'timeseriesSpan'
+func NewSeriesStore(timeseriesSpan time.Duration) SeriesStore {
+	return NewSeriesStoreWithClock(timeseriesSpan, SystemClock)
+}
+
+//NewSeriesStoreWithClock same as NewSeriesStore(..), but using 'clock' as time source for all series
+func NewSeriesStoreWithClock(timeseriesSpan time.Duration, clock Clock) SeriesStore {
+	return SeriesStore{
+		TimeseriesSpan: timeseriesSpan,
+		series:         make(map[string]*Series),
+		clock:          clock,
+		m:              &sync.RWMutex{},
+	}
+}
+
+//Timeseries gets the gauge series with 'labels', creating it if it doesn't exist
+//Returns an error if there is a counter with these labels
+func (s *SeriesStore) Timeseries(labels Labels) (*Timeseries, error) {
+	se, err := s.getOrCreate(labels, false)
+	if err != nil {
+		return nil, err
+	}
+	return se.Timeseries, nil
+}
+
+//Counter gets the counter series with 'labels', creating it if it doesn't exist
+//Returns an error if there is a gauge with these labels
+func (s *SeriesStore) Counter(labels Labels) (*TimeseriesCounterRate, error) {
+	se, err := s.getOrCreate(labels, true)
+	if err != nil {
+		return nil, err
+	}
+	return se.Counter, nil
+}
+
+func (s *SeriesStore) getOrCreate(labels Labels, counter bool) (*Series, error) {
+	key := labels.key()
+	s.m.RLock()
+	se, ok := s.series[key]
+	s.m.RUnlock()
+	if !ok {
+		s.m.Lock()
+		defer s.m.Unlock()
+		se, ok = s.series[key]
+		if !ok {
+			se = &Series{Labels: labels.copy()}
+			if counter {
+				c := NewTimeseriesCounterRateWithClock(s.TimeseriesSpan, s.clock)
+				se.Counter = &c
+			} else {
+				ts := NewTimeseriesWithClock(s.TimeseriesSpan, s.clock)
+				se.Timeseries = &ts
+			}
+			se.points().SetRetentionPolicy(s.retentionPolicy)
+			s.series[key] = se
+		}
+	}
+	if (se.Counter != nil) != counter {
+		return nil, fmt.Errorf("series %s already exists with another type", labels)
+	}
+	return se, nil
+}
+
+//Get gets the series with exactly 'labels'
+func (s *SeriesStore) Get(labels Labels) (*Series, bool) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	se, ok := s.series[labels.key()]
+	return se, ok
+}
+
+//Select gets all series matched by all 'matchers', ordered by labels
+func (s *SeriesStore) Select(matchers ...LabelMatcher) []*Series {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	keys := make([]string, 0)
+	for k, se := range s.series {
+		matches := true
+		for _, m := range matchers {
+			if !m.Matches(se.Labels) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	result := make([]*Series, len(keys))
+	for i, k := range keys {
+		result[i] = s.series[k]
+	}
+	return result
+}
+
+//Delete removes the series with 'labels'. Returns false if it doesn't exist
+func (s *SeriesStore) Delete(labels Labels) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	key := labels.key()
+	_, ok := s.series[key]
+	delete(s.series, key)
+	return ok
+}
+
+//Len number of series in store
+func (s *SeriesStore) Len() int {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return len(s.series)
+}
+
+//SetRetentionPolicy sets the retention policy of all series, including the ones created later
+func (s *SeriesStore) SetRetentionPolicy(policy RetentionPolicy) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.retentionPolicy = policy
+	for _, se := range s.series {
+		se.points().SetRetentionPolicy(policy)
+	}
+}
+
+//Prune prunes old points from all series. Series are never removed, so pointers returned by
+//Timeseries(..) and Counter(..) keep writing to series seen by Select(..). Use DeleteStale() to remove series.
+//For RetentionLastSample, the age of points is relative to the newest point among all series
+func (s *SeriesStore) Prune() {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	ref := s.retentionRef()
+	for _, se := range s.series {
+		ts := se.points()
+		ts.m.Lock()
+		ts.pruneAt(ref)
+		ts.m.Unlock()
+	}
+}
+
+//DeleteStale removes the series without points newer than TimeseriesSpan and returns their labels.
+//Series without points are kept, as they may have just been created.
+//Pointers previously returned by Timeseries(..) and Counter(..) for removed series are detached from this store:
+//points added through them are not seen anymore, and the next Timeseries(..) or Counter(..) creates a new series
+func (s *SeriesStore) DeleteStale() []Labels {
+	s.m.Lock()
+	defer s.m.Unlock()
+	limit := s.retentionRef().Add(-s.TimeseriesSpan)
+	removed := make([]Labels, 0)
+	for k, se := range s.series {
+		l, ok := se.points().Last()
+		if ok && l.Time.Before(limit) {
+			delete(s.series, k)
+			removed = append(removed, se.Labels)
+		}
+	}
+	return removed
+}
+
+//retentionRef time from which TimeseriesSpan is counted back for all series
+func (s *SeriesStore) retentionRef() time.Time {
+	if s.retentionPolicy.Reference != RetentionLastSample {
+		return s.clock.Now()
+	}
+	ref := time.Time{}
+	for _, se := range s.series {
+		if l, ok := se.points().Last(); ok && l.Time.After(ref) {
+			ref = l.Time
+		}
+	}
+	return ref
+}
+
+//StartPruning launches a worker that prunes all series every 'interval' until 'ctx' is done
+func (s *SeriesStore) StartPruning(ctx context.Context, interval time.Duration) *Worker {
+	return StartWorkerWithClock(ctx, "series-store-prune", func() error {
+		s.Prune()
+		return nil
+	}, 0, float64(time.Second)/float64(interval), false, s.clock)
+}
@@ -0,0 +1,198 @@
+package signalutils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSeriesStoreGetOrCreate(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewSeriesStoreWithClock(1*time.Minute, c)
+	labels := Labels{MetricNameLabel: "temperature", "room": "kitchen"}
+	ts, err := s.Timeseries(labels)
+	assert.Nil(t, err)
+	ts.Add(21)
+	//labels are copied
+	labels["room"] = "bedroom"
+
+	ts2, err := s.Timeseries(Labels{"room": "kitchen", MetricNameLabel: "temperature", "floor": ""})
+	assert.Nil(t, err)
+	assert.True(t, ts == ts2)
+	assert.Equal(t, 1, ts2.Size())
+	assert.Equal(t, 1, s.Len())
+
+	_, err = s.Counter(Labels{MetricNameLabel: "temperature", "room": "kitchen"})
+	assert.NotNil(t, err)
+
+	cnt, err := s.Counter(Labels{MetricNameLabel: "requests"})
+	assert.Nil(t, err)
+	cnt.Inc(10)
+	_, err = s.Timeseries(Labels{MetricNameLabel: "requests"})
+	assert.NotNil(t, err)
+
+	se, ok := s.Get(Labels{MetricNameLabel: "requests"})
+	assert.True(t, ok)
+	assert.True(t, se.Counter == cnt)
+	assert.Nil(t, se.Timeseries)
+
+	assert.True(t, s.Delete(Labels{MetricNameLabel: "requests"}))
+	assert.False(t, s.Delete(Labels{MetricNameLabel: "requests"}))
+	_, ok = s.Get(Labels{MetricNameLabel: "requests"})
+	assert.False(t, ok)
+}
+
+func TestSeriesStoreSelect(t *testing.T) {
+	s := NewSeriesStore(1 * time.Minute)
+	for _, code := range []string{"200", "404", "500"} {
+		for _, method := range []string{"GET", "POST"} {
+			s.Counter(Labels{MetricNameLabel: "http_requests", "code": code, "method": method})
+		}
+	}
+	s.Timeseries(Labels{MetricNameLabel: "cpu"})
+
+	assert.Equal(t, 7, len(s.Select()))
+
+	name, _ := NewLabelMatcher(MatchEqual, MetricNameLabel, "http_requests")
+	errors, _ := NewLabelMatcher(MatchRegexp, "code", "[45]..")
+	notGet, _ := NewLabelMatcher(MatchNotEqual, "method", "GET")
+	r := s.Select(name, errors, notGet)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, `http_requests{code="404", method="POST"}`, r[0].Labels.String())
+	assert.Equal(t, `http_requests{code="500", method="POST"}`, r[1].Labels.String())
+
+	noCode, _ := NewLabelMatcher(MatchEqual, "code", "")
+	r = s.Select(noCode)
+	assert.Equal(t, 1, len(r))
+	assert.Equal(t, "cpu", r[0].Labels[MetricNameLabel])
+
+	notHTTP, _ := NewLabelMatcher(MatchNotRegexp, MetricNameLabel, "http_.*")
+	assert.Equal(t, 1, len(s.Select(notHTTP)))
+}
+
+func TestSeriesStoreRetention(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewSeriesStoreWithClock(10*time.Second, c)
+	s.SetRetentionPolicy(RetentionPolicy{MaxPoints: 3})
+	a, _ := s.Timeseries(Labels{"s": "a"})
+	b, _ := s.Timeseries(Labels{"s": "b"})
+	for i := 0; i < 5; i++ {
+		a.Add(float64(i))
+		b.Add(float64(i))
+		c.Advance(1 * time.Second)
+	}
+	assert.Equal(t, 3, a.Size())
+	s.SetRetentionPolicy(RetentionPolicy{MaxPoints: 2, PruneEvery: -1})
+	assert.Equal(t, 2, b.Size())
+	created, _ := s.Timeseries(Labels{"s": "c"})
+	for i := 0; i < 5; i++ {
+		created.Add(float64(i))
+		c.Advance(1 * time.Second)
+	}
+	assert.Equal(t, 2, created.Size())
+
+	//series 'a' and 'b' are stale
+	c.Advance(6 * time.Second)
+	s.Prune()
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, 1, a.Size())
+	removed := s.DeleteStale()
+	assert.ElementsMatch(t, []Labels{{"s": "a"}, {"s": "b"}}, removed)
+	assert.Equal(t, 1, s.Len())
+	_, ok := s.Get(Labels{"s": "c"})
+	assert.True(t, ok)
+}
+
+func TestSeriesStoreRetentionLastSample(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSeriesStoreWithClock(10*time.Second, NewManualClock(start.Add(24*time.Hour)))
+	s.SetRetentionPolicy(RetentionPolicy{Reference: RetentionLastSample, PruneEvery: -1})
+	a, _ := s.Timeseries(Labels{"s": "a"})
+	b, _ := s.Timeseries(Labels{"s": "b"})
+	for i := 0; i < 30; i++ {
+		a.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+		if i < 15 {
+			b.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+		}
+	}
+	s.Prune()
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 13, a.Size())
+	assert.Equal(t, 1, b.Size())
+	assert.Equal(t, 1, len(s.DeleteStale()))
+	assert.Equal(t, 1, s.Len())
+
+	//lagging series are pruned relative to the newest point among all series
+	c, _ := s.Timeseries(Labels{"s": "c"})
+	for i := 0; i <= 22; i++ {
+		c.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, 23, c.Size())
+	s.Prune()
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 6, c.Size())
+}
+
+func TestSeriesStorePruneKeepsEmptySeries(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewSeriesStoreWithClock(1*time.Second, c)
+	ts, _ := s.Timeseries(Labels{"s": "a"})
+	cnt, _ := s.Counter(Labels{"s": "b"})
+	s.Prune()
+	assert.Equal(t, 0, len(s.DeleteStale()))
+	assert.Equal(t, 2, s.Len())
+
+	//writes after pruning are kept in the store
+	ts.Add(1)
+	cnt.Inc(1)
+	se, ok := s.Get(Labels{"s": "a"})
+	assert.True(t, ok)
+	assert.Equal(t, 1, se.Timeseries.Size())
+	se, _ = s.Get(Labels{"s": "b"})
+	assert.Equal(t, 1, se.Counter.Timeseries.Size())
+}
+
+func TestSeriesStoreBackgroundPruning(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewSeriesStoreWithClock(1*time.Second, c)
+	ts, _ := s.Timeseries(Labels{"s": "a"})
+	for i := 0; i < 5; i++ {
+		ts.Add(float64(i))
+		c.Advance(1 * time.Second)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.StartPruning(ctx, 5*time.Second)
+	c.Advance(5 * time.Second)
+	assert.Eventually(t, func() bool {
+		return ts.Size() == 1
+	}, 1*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestSeriesStorePruneKeepsHandles(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewSeriesStoreWithClock(5*time.Second, c)
+	ts, _ := s.Timeseries(Labels{"s": "a", "t": "gauge"})
+	cnt, _ := s.Counter(Labels{"s": "b", "t": "counter"})
+	ts.Add(1)
+	cnt.Inc(10)
+
+	//series are idle for longer than their span
+	c.Advance(1 * time.Minute)
+	s.Prune()
+
+	//writes through old handles are still seen by queries
+	ts.Add(2)
+	cnt.Inc(5)
+	v, err := s.Query(`{s="a"}`, c.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(v))
+	assert.Equal(t, 2.0, v[0].Value)
+	c2, _ := s.Counter(Labels{"s": "b", "t": "counter"})
+	assert.True(t, c2 == cnt)
+	l, _ := c2.Timeseries.Last()
+	assert.Equal(t, 15.0, l.Value)
+}
@@ -0,0 +1,157 @@
+package signalutils
+
+import "math"
+
+type dequeItem struct {
+	seq   int64
+	value float64
+}
+
+//monotonicDeque keeps the candidates for the min (or max) value of a sliding window
+//so that the current min/max can be queried in O(1) and pushing/expiring samples is amortized O(1)
+type monotonicDeque struct {
+	items []dequeItem
+	start int
+	max   bool
+}
+
+func newMonotonicDeque(max bool) monotonicDeque {
+	return monotonicDeque{
+		items: make([]dequeItem, 0),
+		max:   max,
+	}
+}
+
+//push adds a new sample to the tail of the window
+func (d *monotonicDeque) push(seq int64, value float64) {
+	for len(d.items) > d.start {
+		last := d.items[len(d.items)-1].value
+		if (d.max && last > value) || (!d.max && last < value) {
+			break
+		}
+		d.items = d.items[:len(d.items)-1]
+	}
+	d.items = append(d.items, dequeItem{seq, value})
+}
+
+//pop removes sample 'seq' from the head of the window
+func (d *monotonicDeque) pop(seq int64) {
+	if d.start < len(d.items) && d.items[d.start].seq == seq {
+		d.items[d.start] = dequeItem{}
+		d.start = d.start + 1
+	}
+	if d.start == len(d.items) {
+		d.items = d.items[:0]
+		d.start = 0
+	} else if d.start > 32 && d.start*2 >= len(d.items) {
+		n := copy(d.items, d.items[d.start:])
+		d.items = d.items[:n]
+		d.start = 0
+	}
+}
+
+func (d *monotonicDeque) front() (float64, bool) {
+	if d.start == len(d.items) {
+		return math.NaN(), false
+	}
+	return d.items[d.start].value, true
+}
+
+func (d *monotonicDeque) reset() {
+	d.items = d.items[:0]
+	d.start = 0
+}
+
+//groupedMinMax keeps the sums of the min and max values of groups of 'groupBy' consecutive samples of a sliding window
+//Groups are formed from the oldest sample in window, so they change whenever the window slides. In order not to
+//recalculate all groups on each change, the min/max of the 'groupBy' samples starting at each sample is calculated
+//once, when the last sample of the group is added, and summed by the position of its first sample modulo 'groupBy'.
+//The groups in the window are the ones with the same position modulo 'groupBy' as the oldest sample, plus
+//a last partial group, which must be calculated from the samples
+type groupedMinMax struct {
+	groupBy int64
+	//min/max of the last 'groupBy' samples added
+	min    monotonicDeque
+	max    monotonicDeque
+	pushed int64
+	//expired samples before this sequence number were removed from the window
+	expired int64
+	//groups min/max of the groups starting at each sample in window
+	groups []groupStats
+	start  int
+	sumMin []kahanSum
+	sumMax []kahanSum
+}
+
+type groupStats struct {
+	seq int64
+	min float64
+	max float64
+}
+
+func newGroupedMinMax(groupBy int) groupedMinMax {
+	if groupBy < 1 {
+		groupBy = 1
+	}
+	return groupedMinMax{
+		groupBy: int64(groupBy),
+		min:     newMonotonicDeque(false),
+		max:     newMonotonicDeque(true),
+		groups:  make([]groupStats, 0),
+		sumMin:  make([]kahanSum, groupBy),
+		sumMax:  make([]kahanSum, groupBy),
+	}
+}
+
+//push adds a new sample to the tail of the window
+func (g *groupedMinMax) push(seq int64, value float64) {
+	g.min.push(seq, value)
+	g.max.push(seq, value)
+	g.min.pop(seq - g.groupBy)
+	g.max.pop(seq - g.groupBy)
+	g.pushed = g.pushed + 1
+	if g.pushed < g.groupBy {
+		return
+	}
+	//group starting at 'first' is complete. It is not in window anymore if the window is smaller than a group
+	first := seq - g.groupBy + 1
+	if first < g.expired {
+		return
+	}
+	min, _ := g.min.front()
+	max, _ := g.max.front()
+	g.groups = append(g.groups, groupStats{first, min, max})
+	g.sumMin[first%g.groupBy].add(min)
+	g.sumMax[first%g.groupBy].add(max)
+}
+
+//pop removes sample 'seq' from the head of the window
+func (g *groupedMinMax) pop(seq int64) {
+	g.expired = seq + 1
+	if g.start == len(g.groups) || g.groups[g.start].seq != seq {
+		return
+	}
+	gs := g.groups[g.start]
+	g.sumMin[seq%g.groupBy].add(-gs.min)
+	g.sumMax[seq%g.groupBy].add(-gs.max)
+	g.start = g.start + 1
+	if g.start == len(g.groups) {
+		g.groups = g.groups[:0]
+		g.start = 0
+	} else if g.start > 32 && g.start*2 >= len(g.groups) {
+		n := copy(g.groups, g.groups[g.start:])
+		g.groups = g.groups[:n]
+		g.start = 0
+	}
+}
+
+//sums returns the sums of the min and max values of the complete groups of a window starting at sample 'oldest'
+func (g *groupedMinMax) sums(oldest int64) (float64, float64) {
+	return g.sumMin[oldest%g.groupBy].value(), g.sumMax[oldest%g.groupBy].value()
+}
+
+func (g *groupedMinMax) reset() {
+	if g.groupBy > 0 {
+		*g = newGroupedMinMax(int(g.groupBy))
+	}
+}
@@ -20,6 +20,8 @@ type StateTracker struct {
 	highestLevel            float64
 	resetHighestOnunchanged bool
 	active                  bool
+	done                    chan struct{}
+	clock                   Clock
 	m                       *sync.Mutex
 }
 
@@ -43,14 +45,19 @@ type State struct {
 //onUnchanged - listener function to be invoked if state is not changed after unchangedStateCount. onUnchanged(state). nil value disables this feature
 //resetHighestOnunchanged - calculate highest level according to whole state duration (false) or only during the onChanged recurrent timer
 func NewStateTracker(initialState string, changeConfirmations int, onChange func(*State, *State), unchangedTimer time.Duration, onUnchanged func(*State), resetHighestOnunchanged bool) *StateTracker {
+	return NewStateTrackerWithClock(initialState, changeConfirmations, onChange, unchangedTimer, onUnchanged, resetHighestOnunchanged, SystemClock)
+}
+
+//NewStateTrackerWithClock same as NewStateTracker(..), but using 'clock' as time source for state times and for the unchanged timer
+func NewStateTrackerWithClock(initialState string, changeConfirmations int, onChange func(*State, *State), unchangedTimer time.Duration, onUnchanged func(*State), resetHighestOnunchanged bool, clock Clock) *StateTracker {
 	state := State{
 		Name:  initialState,
-		Start: time.Now(),
+		Start: clock.Now(),
 	}
 	s1 := StateTracker{
 		onChange:                onChange,
 		CurrentState:            &state,
-		lastUnchanged:           time.Now(),
+		lastUnchanged:           clock.Now(),
 		CandidateState:          "",
 		CandidateCount:          0,
 		changeConfirmations:     changeConfirmations,
@@ -58,7 +65,9 @@ func NewStateTracker(initialState string, changeConfirmations int, onChange func
 		onUnchanged:             onUnchanged,
 		highestLevel:            -math.MaxFloat64,
 		active:                  true,
+		done:                    make(chan struct{}),
 		resetHighestOnunchanged: resetHighestOnunchanged,
+		clock:                   clock,
 		m:                       &sync.Mutex{},
 	}
 	go s1.verifyUnchanged()
@@ -90,7 +99,7 @@ func (s *StateTracker) SetTransientStateWithData(stateName string, level float64
 			s.highestLevel = level
 			s.CurrentState.HighestLevel = &level
 			s.CurrentState.HighestData = data
-			now := time.Now()
+			now := s.clock.Now()
 			s.CurrentState.HighestTime = &now
 		}
 
@@ -114,11 +123,11 @@ func (s *StateTracker) SetTransientStateWithData(stateName string, level float64
 	if s.CandidateCount >= s.changeConfirmations {
 		// fmt.Printf("Candidate confirm! candidateCount=%d changeConfirmations=%d state=%s\n", s.CandidateCount, s.changeConfirmations, state)
 		prevState := s.CurrentState
-		now := time.Now()
+		now := s.clock.Now()
 		prevState.Stop = &now
 		s.CurrentState = &State{
 			Name:  stateName,
-			Start: time.Now(),
+			Start: s.clock.Now(),
 			Data:  data,
 		}
 		if s.onChange != nil {
@@ -128,7 +137,7 @@ func (s *StateTracker) SetTransientStateWithData(stateName string, level float64
 		s.CandidateState = ""
 		s.CandidateCount = 0
 		s.highestLevel = -math.MaxFloat64
-		s.lastUnchanged = time.Now()
+		s.lastUnchanged = s.clock.Now()
 	}
 
 	return s.CurrentState, nil
@@ -138,17 +147,24 @@ func (s *StateTracker) SetTransientStateWithData(stateName string, level float64
 func (s *StateTracker) Close() {
 	s.m.Lock()
 	defer s.m.Unlock()
-	s.active = false
+	if s.active {
+		s.active = false
+		close(s.done)
+	}
 }
 
 func (s *StateTracker) verifyUnchanged() {
-	for ok := s.active; ok; ok = s.active {
+	for {
 		s.m.Lock()
+		if !s.active {
+			s.m.Unlock()
+			return
+		}
 		// fmt.Printf(">>> VERIFY UNCHANGED current=%s\n", s.CurrentState)
-		elapsed := time.Duration((time.Now().UnixNano() - s.lastUnchanged.UnixNano()))
+		elapsed := time.Duration((s.clock.Now().UnixNano() - s.lastUnchanged.UnixNano()))
 		if s.onUnchanged != nil && (elapsed.Nanoseconds() > s.unchangedTimer.Nanoseconds()) {
 			// fmt.Printf("NOTIFY %s\n", s.CurrentState)
-			s.lastUnchanged = time.Now()
+			s.lastUnchanged = s.clock.Now()
 			if s.resetHighestOnunchanged {
 				s.highestLevel = -math.MaxFloat64
 			}
@@ -156,6 +172,13 @@ func (s *StateTracker) verifyUnchanged() {
 			onUnchanged(s.CurrentState)
 		}
 		s.m.Unlock()
-		time.Sleep(s.unchangedTimer / 2)
+		//wake up on Close() too, as a ManualClock may never be advanced again
+		timer := s.clock.NewTimer(s.unchangedTimer / 2)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
 	}
 }
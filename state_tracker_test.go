@@ -8,19 +8,20 @@ import (
 )
 
 var (
-	notifiedNewState       *State
-	notifiedPreviousState  *State
-	notifiedUnchangedState *State
+	notifiedNewState      *State
+	notifiedPreviousState *State
 )
 
 func TestStateTracker1(t *testing.T) {
-	st := NewStateTracker("state1", 0, onChange, 0, nil, true)
+	st := NewStateTrackerWithClock("state1", 0, onChange, 0, nil, true, NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	defer st.Close()
 	st.SetTransientState("state2")
 	assert.Equal(t, "state2", notifiedNewState.Name)
 }
 
 func TestStateTracker2(t *testing.T) {
-	st := NewStateTracker("state1", 3, onChange, 0, nil, true)
+	st := NewStateTrackerWithClock("state1", 3, onChange, 0, nil, true, NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)))
+	defer st.Close()
 	st.SetTransientState("state2")
 	st.SetTransientState("state2")
 	assert.Equal(t, "state1", st.CurrentState.Name)
@@ -41,78 +42,101 @@ func TestStateTracker2(t *testing.T) {
 	assert.Equal(t, "state3", st.CurrentState.Name)
 }
 
+//newUnchangedTestTracker state tracker driven by a ManualClock whose unchanged notifications are sent to the returned channel
+func newUnchangedTestTracker(initialState string, unchangedTimer time.Duration, resetHighestOnunchanged bool) (*StateTracker, *ManualClock, chan *State) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	unchanged := make(chan *State, 10)
+	st := NewStateTrackerWithClock(initialState, 3, onChange, unchangedTimer, func(s *State) {
+		unchanged <- s
+	}, resetHighestOnunchanged, c)
+	return st, c, unchanged
+}
+
+//advanceStateTracker advances the clock by 'd' in steps of half the unchanged timer,
+//waiting for the tracker to verify the state after each step
+func advanceStateTracker(st *StateTracker, c *ManualClock, d time.Duration) {
+	step := st.unchangedTimer / 2
+	for elapsed := time.Duration(0); elapsed < d; elapsed = elapsed + step {
+		c.BlockUntil(1)
+		c.Advance(step)
+	}
+	c.BlockUntil(1)
+}
+
 func TestStateTrackerOnUnchanged(t *testing.T) {
-	notifiedUnchangedState = nil
-	st := NewStateTracker("state1", 3, onChange, 300*time.Millisecond, onUnchanged, true)
+	st, c, unchanged := newUnchangedTestTracker("state1", 300*time.Millisecond, true)
+	defer st.Close()
 	st.SetTransientState("state2")
-	assert.Nil(t, notifiedUnchangedState)
+	assert.Equal(t, 0, len(unchanged))
 	st.SetTransientState("state2")
 	assert.Equal(t, "state1", st.CurrentState.Name)
 	st.SetTransientState("state2")
 	assert.Equal(t, "state2", st.CurrentState.Name)
-	assert.Nil(t, notifiedUnchangedState)
+	assert.Equal(t, 0, len(unchanged))
 	st.SetTransientState("state2")
-	time.Sleep(500 * time.Millisecond)
-	assert.NotNil(t, notifiedUnchangedState)
-	assert.Equal(t, "state2", notifiedUnchangedState.Name)
-	notifiedUnchangedState = nil
+	advanceStateTracker(st, c, 450*time.Millisecond)
+	assert.Equal(t, 1, len(unchanged))
+	assert.Equal(t, "state2", (<-unchanged).Name)
 	st.SetTransientState("state2")
-	assert.Nil(t, notifiedUnchangedState)
+	assert.Equal(t, 0, len(unchanged))
 	st.SetTransientState("state2")
-	time.Sleep(500 * time.Millisecond)
-	assert.Equal(t, "state2", notifiedUnchangedState.Name)
+	advanceStateTracker(st, c, 450*time.Millisecond)
+	assert.Equal(t, 1, len(unchanged))
+	assert.Equal(t, "state2", (<-unchanged).Name)
 }
 
 func TestStateTrackerHighest(t *testing.T) {
-	st := NewStateTracker("state1", 3, onChange, 100*time.Millisecond, onUnchanged, true)
+	st, c, unchanged := newUnchangedTestTracker("state1", 100*time.Millisecond, true)
+	defer st.Close()
 	st.SetTransientState("state2")
 	st.SetTransientState("state2")
 	st.SetTransientStateWithData("state2", 10.0, 10.0)
 	st.SetTransientStateWithData("state2", 20.0, 20.0)
 	st.SetTransientStateWithData("state2", 5.0, 5.0)
 	assert.Equal(t, 20.0, *st.CurrentState.HighestLevel)
-	notifiedUnchangedState = nil
-	time.Sleep(110 * time.Millisecond)
+	advanceStateTracker(st, c, 150*time.Millisecond)
+	assert.Equal(t, 1, len(unchanged))
+	<-unchanged
 	st.SetTransientStateWithData("state2", 15.0, 15.0)
 	assert.Equal(t, 15.0, *st.CurrentState.HighestLevel)
 	st.SetTransientStateWithData("state2", 40.0, 40.0)
 	st.SetTransientStateWithData("state2", 41.0, 41.0)
 	st.SetTransientStateWithData("state2", 15.0, 15.0)
 	assert.Equal(t, 41.0, st.CurrentState.HighestData.(float64))
-	notifiedUnchangedState = nil
-	time.Sleep(110 * time.Millisecond)
+	advanceStateTracker(st, c, 150*time.Millisecond)
 	assert.Equal(t, 41.0, *st.CurrentState.HighestLevel)
-	assert.NotNil(t, notifiedUnchangedState)
-	notifiedUnchangedState = nil
+	assert.Equal(t, 1, len(unchanged))
+	<-unchanged
 	st.SetTransientStateWithData("state2", 30.0, 30.0)
 	assert.Equal(t, 30.0, *st.CurrentState.HighestLevel)
-	assert.Nil(t, notifiedUnchangedState)
+	assert.Equal(t, 0, len(unchanged))
 }
 
 func TestStateTrackerHighest2(t *testing.T) {
-	st := NewStateTracker("state2", 3, onChange, 100*time.Millisecond, onUnchanged, false)
+	st, c, unchanged := newUnchangedTestTracker("state2", 100*time.Millisecond, false)
+	defer st.Close()
 	st.SetTransientState("state2")
 	st.SetTransientState("state2")
 	st.SetTransientStateWithData("state2", 10.0, 10.0)
 	st.SetTransientStateWithData("state2", 20.0, 20.0)
 	st.SetTransientStateWithData("state2", 5.0, 5.0)
 	assert.Equal(t, 20.0, *st.CurrentState.HighestLevel)
-	notifiedUnchangedState = nil
-	time.Sleep(110 * time.Millisecond)
+	advanceStateTracker(st, c, 150*time.Millisecond)
+	assert.Equal(t, 1, len(unchanged))
+	<-unchanged
 	st.SetTransientStateWithData("state2", 15.0, 15.0)
 	assert.Equal(t, 20.0, *st.CurrentState.HighestLevel)
 	st.SetTransientStateWithData("state2", 40.0, 40.0)
 	st.SetTransientStateWithData("state2", 41.0, 41.0)
 	st.SetTransientStateWithData("state2", 15.0, 15.0)
 	assert.Equal(t, 41.0, st.CurrentState.HighestData.(float64))
-	notifiedUnchangedState = nil
-	time.Sleep(110 * time.Millisecond)
+	advanceStateTracker(st, c, 150*time.Millisecond)
 	assert.Equal(t, 41.0, *st.CurrentState.HighestLevel)
-	assert.NotNil(t, notifiedUnchangedState)
-	notifiedUnchangedState = nil
+	assert.Equal(t, 1, len(unchanged))
+	<-unchanged
 	st.SetTransientStateWithData("state2", 30.0, 30.0)
 	assert.Equal(t, 41.0, *st.CurrentState.HighestLevel)
-	assert.Nil(t, notifiedUnchangedState)
+	assert.Equal(t, 0, len(unchanged))
 }
 
 func onChange(prevState *State, curState *State) {
@@ -120,6 +144,48 @@ func onChange(prevState *State, curState *State) {
 	notifiedNewState = curState
 }
 
-func onUnchanged(curState *State) {
-	notifiedUnchangedState = curState
+func TestStateTrackerOnUnchangedManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	unchanged := make(chan *State, 10)
+	st := NewStateTrackerWithClock("state1", 2, nil, 300*time.Millisecond, func(s *State) {
+		unchanged <- s
+	}, true, c)
+	defer st.Close()
+	st.SetTransientState("state2")
+	st.SetTransientState("state2")
+	assert.Equal(t, "state2", st.CurrentState.Name)
+	assert.Equal(t, c.Now(), st.CurrentState.Start)
+
+	c.BlockUntil(1)
+	c.Advance(150 * time.Millisecond)
+	c.BlockUntil(1)
+	assert.Equal(t, 0, len(unchanged))
+	c.Advance(200 * time.Millisecond)
+	s := <-unchanged
+	assert.Equal(t, "state2", s.Name)
+}
+
+func TestStateTrackerCloseManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	for i := 0; i < 10; i++ {
+		st := NewStateTrackerWithClock("state1", 2, nil, 300*time.Millisecond, func(s *State) {}, true, c)
+		c.BlockUntil(1)
+		st.Close()
+		st.Close()
+		//the verify goroutine exits and stops its timer even though the clock is never advanced
+		waitManualClockWaiters(t, c, 0)
+	}
+}
+
+//waitManualClockWaiters waits for a short while until exactly 'n' timers or tickers are waiting on 'c'
+func waitManualClockWaiters(t *testing.T, c *ManualClock, n int) {
+	waiters := func() int {
+		c.m.Lock()
+		defer c.m.Unlock()
+		return len(c.waiters)
+	}
+	for i := 0; i < 100 && waiters() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, n, waiters())
 }
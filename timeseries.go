@@ -2,10 +2,14 @@ package signalutils
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gonum/stat"
+	"github.com/sirupsen/logrus"
 )
 
 //TimeValue a point in time
@@ -15,56 +19,103 @@ type TimeValue struct {
 }
 
 //Timeseries utility
-//Only initialize this with NewTimeseries(..)
+//Only initialize this with NewTimeseries(..) or NewCompressedTimeseries(..)
+//Values has all points of uncompressed timeseries. It is always empty on compressed timeseries,
+//so prefer using Iterator() or ValuesRange(..) for reading points, which are also safe for concurrent use
 type Timeseries struct {
-	TimeseriesSpan time.Duration
-	Values         []TimeValue
-	m              *sync.RWMutex
-	// gc             int
+	TimeseriesSpan  time.Duration
+	Values          []TimeValue
+	chunks          *chunkStore
+	wal             *WAL
+	shared          int32
+	clock           Clock
+	insertPolicy    InsertPolicy
+	dropped         int64
+	retentionPolicy RetentionPolicy
+	additions       int
+	m               *sync.RWMutex
 }
 
 //NewTimeseries create a new timeseries with a limited size in time.
 //After that limit older values will be deleted from time to time to
 //avoid too much memory usage
 func NewTimeseries(maxTimeseriesSpan time.Duration) Timeseries {
+	return NewTimeseriesWithClock(maxTimeseriesSpan, SystemClock)
+}
+
+//NewTimeseriesWithClock same as NewTimeseries(..), but using 'clock' as time source
+func NewTimeseriesWithClock(maxTimeseriesSpan time.Duration, clock Clock) Timeseries {
 	return Timeseries{
 		TimeseriesSpan: maxTimeseriesSpan,
 		Values:         make([]TimeValue, 0),
+		clock:          clock,
 		m:              &sync.RWMutex{},
 	}
 }
 
-//Add add a new sample to this timeseries using time.Now()
+//NewCompressedTimeseries same as NewTimeseries(..), but points are kept in compressed chunks
+//using delta of delta encoding for timestamps and XOR encoding for values (Gorilla), so that regularly spaced
+//points use just a few bytes each instead of 32 bytes. Reading points is slower, as they must be decoded
+//Times are stored with nanosecond precision and with the location of the first point added
+func NewCompressedTimeseries(maxTimeseriesSpan time.Duration) Timeseries {
+	return NewCompressedTimeseriesWithClock(maxTimeseriesSpan, SystemClock)
+}
+
+//NewCompressedTimeseriesWithClock same as NewCompressedTimeseries(..), but using 'clock' as time source
+func NewCompressedTimeseriesWithClock(maxTimeseriesSpan time.Duration, clock Clock) Timeseries {
+	t := NewTimeseriesWithClock(maxTimeseriesSpan, clock)
+	t.chunks = newChunkStore()
+	return t
+}
+
+//newDerivedTimeseries creates a timeseries with 'points' calculated from other series (resampling, queries etc).
+//Points are never pruned on additions, as they are usually older than TimeseriesSpan before the current time
+func newDerivedTimeseries(span time.Duration, clock Clock, compressed bool, points []TimeValue) Timeseries {
+	t := NewTimeseriesWithClock(span, clock)
+	if compressed {
+		t = NewCompressedTimeseriesWithClock(span, clock)
+	}
+	t.retentionPolicy.PruneEvery = -1
+	for i, p := range points {
+		t.insert(i, p)
+	}
+	return t
+}
+
+//Add add a new sample to this timeseries using current clock time
 func (t *Timeseries) Add(value float64) {
-	t.AddWithTime(value, time.Now())
+	t.AddWithTime(value, t.clock.Now())
 }
 
 //AddWithTime adds ad new sample to the head of this timeseries
-//'when' must be after the last element (no middle insertions allowed)
+//'when' must be after the last element, unless allowed by the insert policy (see SetInsertPolicy(..))
+//Returns an error wrapping ErrPointDropped if the point was dropped, or the error of the WAL, if attached
 func (t *Timeseries) AddWithTime(value float64, when time.Time) error {
 	t.m.Lock()
 	defer t.m.Unlock()
-	l, ok := t.last()
-	if ok {
-		if len(t.Values) == 1 || l.Time.Before(when) {
-			t.Values = append(t.Values, TimeValue{when, value})
-
-			//CLEANUP OLDER DATA
-			//TODO: minimize cleanup frequency
-			// t.gc = t.gc + 1
-			// if t.gc > 5 {
-			i1, _, ok := t.pos(time.Now().Add(-t.TimeseriesSpan - 1*time.Second))
-			if ok && i1 > 1 {
-				t.Values = t.Values[i1-1:]
-			}
-			// t.gc = 0
-			// }
-
-			return nil
+	return t.addWithTime(value, when, t.wal)
+}
+
+//addWithTime adds a point according to the insert policy, recording it in 'wal' before, if not nil
+func (t *Timeseries) addWithTime(value float64, when time.Time, wal *WAL) error {
+	i, duplicate, ok := t.insertIndex(when)
+	if !ok || (duplicate && t.insertPolicy.Duplicate == DuplicateReject) {
+		t.dropped = t.dropped + 1
+		l, _ := t.last()
+		return fmt.Errorf("%w. when=%v last=%v", ErrPointDropped, when, l)
+	}
+	if wal != nil {
+		err := wal.Append(TimeValue{when, value})
+		if err != nil {
+			return err
 		}
-		return fmt.Errorf("'when' must be after the last element in this timeseries. when=%v last=%v", when, l)
 	}
-	t.Values = append(t.Values, TimeValue{when, value})
+	if duplicate {
+		t.mergeDuplicate(i, value)
+		return nil
+	}
+	t.insert(i, TimeValue{when, value})
+	t.afterAdd()
 	return nil
 }
 
@@ -75,27 +126,34 @@ func (t *Timeseries) AddWithTime(value float64, when time.Time) error {
 func (t *Timeseries) Get(time time.Time) (tv TimeValue, ok bool) {
 	t.m.RLock()
 	defer t.m.RUnlock()
+	return t.get(time)
+}
+func (t *Timeseries) get(time time.Time) (tv TimeValue, ok bool) {
 	i1, i2, ok := t.pos(time)
 	if !ok {
 		return TimeValue{}, false
 	}
 	if i1 == i2 {
-		return t.Values[i1], true
+		return t.at(i1), true
+	}
+	return TimeValue{time, interpolate(t.at(i1), t.at(i2), time)}, true
+}
+
+//interpolate linear interpolation of the value at 'when' between points v1 and v2
+func interpolate(v1 TimeValue, v2 TimeValue, when time.Time) float64 {
+	if v1.Time.Equal(v2.Time) {
+		return v1.Value
 	}
-	v1 := t.Values[i1]
-	v2 := t.Values[i2]
-	// fmt.Printf("%f %f", v1.value, v2.value)
 	td := float64(v2.Time.UnixNano() - v1.Time.UnixNano())
 	vd := v2.Value - v1.Value
-	vdr := v1.Value + ((vd / td) * float64(time.UnixNano()-v1.Time.UnixNano()))
-	return TimeValue{time, vdr}, true
+	return v1.Value + ((vd / td) * float64(when.UnixNano()-v1.Time.UnixNano()))
 }
 
 //Size current number of elements in this timeseries
 func (t *Timeseries) Size() int {
 	t.m.RLock()
 	defer t.m.RUnlock()
-	return len(t.Values)
+	return t.size()
 }
 
 //Pos searches for which two point indexes are between the desired time
@@ -106,22 +164,118 @@ func (t *Timeseries) Pos(time time.Time) (i1 int, i2 int, ok bool) {
 	return t.pos(time)
 }
 func (t *Timeseries) pos(time time.Time) (i1 int, i2 int, ok bool) {
-	for i1, v1 := range t.Values {
-		if v1.Time == time {
-			return i1, i1, true
-		}
-		i2 := i1 + 1
-		if i2 < len(t.Values) {
-			v2 := t.Values[i2]
-			if v2.Time == time {
-				return i2, i2, true
-			}
-			if time.After(v1.Time) && time.Before(v2.Time) {
-				return i1, i2, true
-			}
-		}
+	//points are ordered by time, so use binary search
+	i := t.searchFrom(time)
+	if i < t.size() && t.at(i).Time.Equal(time) {
+		return i, i, true
+	}
+	if i == 0 || i == t.size() {
+		return -1, -1, false
+	}
+	return i - 1, i, true
+}
+
+//rangeIndexes slice indexes [i1:i2] of the points between 'from' and 'to' (inclusive)
+func (t *Timeseries) rangeIndexes(from time.Time, to time.Time) (i1 int, i2 int) {
+	i1 = t.searchFrom(from)
+	i2 = t.searchTo(to)
+	if i2 < i1 {
+		i2 = i1
 	}
-	return -1, -1, false
+	return i1, i2
+}
+
+//searchFrom index of the first point with time equal or after 'from'
+func (t *Timeseries) searchFrom(from time.Time) int {
+	return t.search(func(tv TimeValue) bool {
+		return !tv.Time.Before(from)
+	})
+}
+
+//searchTo index of the first point with time after 'to'
+func (t *Timeseries) searchTo(to time.Time) int {
+	return t.search(func(tv TimeValue) bool {
+		return tv.Time.After(to)
+	})
+}
+
+//the following functions access points regardless of the storage being compressed or not
+
+func (t *Timeseries) search(f func(TimeValue) bool) int {
+	if t.chunks != nil {
+		return t.chunks.search(f)
+	}
+	return sort.Search(len(t.Values), func(i int) bool {
+		return f(t.Values[i])
+	})
+}
+
+func (t *Timeseries) size() int {
+	if t.chunks != nil {
+		return t.chunks.size()
+	}
+	return len(t.Values)
+}
+
+func (t *Timeseries) at(i int) TimeValue {
+	if t.chunks != nil {
+		return t.chunks.at(i)
+	}
+	return t.Values[i]
+}
+
+//points points in indexes [i1:i2]. The returned slice must not be changed
+func (t *Timeseries) points(i1 int, i2 int) []TimeValue {
+	if t.chunks != nil {
+		return t.chunks.points(i1, i2)
+	}
+	return t.Values[i1:i2]
+}
+
+func (t *Timeseries) insert(i int, tv TimeValue) {
+	if t.chunks != nil {
+		t.chunks.insert(i, tv)
+		return
+	}
+	if i == len(t.Values) {
+		t.Values = append(t.Values, tv)
+		return
+	}
+	t.unshare()
+	t.Values = append(t.Values, TimeValue{})
+	copy(t.Values[i+1:], t.Values[i:])
+	t.Values[i] = tv
+}
+
+func (t *Timeseries) set(i int, value float64) {
+	if t.chunks != nil {
+		t.chunks.set(i, value)
+		return
+	}
+	t.unshare()
+	t.Values[i].Value = value
+}
+
+//unshare copies the points before changing them in place if they might be in use by an iterator.
+//Appending and dropping points don't change the points seen by iterators
+//The shared flag is set atomically by iterators, as they only hold the read lock
+func (t *Timeseries) unshare() {
+	if atomic.LoadInt32(&t.shared) == 0 {
+		return
+	}
+	vs := make([]TimeValue, len(t.Values), cap(t.Values))
+	copy(vs, t.Values)
+	t.Values = vs
+	atomic.StoreInt32(&t.shared, 0)
+}
+
+//dropFirst removes the 'n' oldest points
+func (t *Timeseries) dropFirst(n int) {
+	if t.chunks != nil {
+		t.chunks.dropFirst(n)
+		return
+	}
+	t.Values = t.Values[n:]
 }
 
 //Reset remove all elements from this timeseries
@@ -129,6 +283,15 @@ func (t *Timeseries) Reset() {
 	t.m.Lock()
 	defer t.m.Unlock()
 	t.Values = make([]TimeValue, 0)
+	if t.chunks != nil {
+		t.chunks = newChunkStore()
+	}
+	if t.wal != nil {
+		err := t.wal.Reset()
+		if err != nil {
+			logrus.Warnf("timeseries: could not reset WAL. err=%s", err)
+		}
+	}
 }
 
 //Last get last point in time element, the head element
@@ -138,6 +301,9 @@ func (t *Timeseries) Last() (tv TimeValue, ok bool) {
 	return t.last()
 }
 func (t *Timeseries) last() (tv TimeValue, ok bool) {
+	if t.chunks != nil {
+		return t.chunks.last()
+	}
 	l := len(t.Values)
 	if l == 0 {
 		return TimeValue{}, false
@@ -147,54 +313,91 @@ func (t *Timeseries) last() (tv TimeValue, ok bool) {
 
 //Avg calculates the average value of points compreended between time 'from' and 'to'
 //No interpolation is used here
+//If there are no points in range, ok is false
 func (t *Timeseries) Avg(from time.Time, to time.Time) (value float64, ok bool) {
 	t.m.RLock()
 	defer t.m.RUnlock()
 	sum := 0.0
 	c := 0
-	for _, v := range t.Values {
-		if (v.Time == from || v.Time.After(from)) && (v.Time == to || v.Time.Before(to)) {
-			sum = sum + v.Value
-			c = c + 1
-		}
+	i1, i2 := t.rangeIndexes(from, to)
+	for _, v := range t.points(i1, i2) {
+		sum = sum + v.Value
+		c = c + 1
+	}
+	if c == 0 {
+		return 0, false
 	}
 	return sum / float64(c), true
 }
 
-//ValuesRange get values in time range
-//returns an array of TimeValue and and array with just the float values
+//ValuesRange get values in time range (from and to inclusive)
+//Only actual points are returned. No interpolated points are added at the boundaries, so
+//the first point may be after 'from' and the last point may be before 'to'
+//returns an array of TimeValue and and array with just the float values. Both are empty if there are no points in range
 func (t *Timeseries) ValuesRange(from time.Time, to time.Time) (timeValues []TimeValue, values []float64) {
 	t.m.RLock()
 	defer t.m.RUnlock()
 	return t.valuesRange(from, to)
 }
 func (t *Timeseries) valuesRange(from time.Time, to time.Time) (timeValues []TimeValue, values []float64) {
-	vs := make([]TimeValue, 0)
-	values = make([]float64, 0)
-	for _, v := range t.Values {
-		vs = append(vs, v)
-		values = append(values, v.Value)
+	i1, i2 := t.rangeIndexes(from, to)
+	vs := make([]TimeValue, i2-i1)
+	copy(vs, t.points(i1, i2))
+	values = make([]float64, len(vs))
+	for i, v := range vs {
+		values[i] = v.Value
+	}
+	return vs, values
+}
+
+//ValuesRangeInterpolated same as ValuesRange(..), but if 'from' or 'to' are between two points
+//of this timeseries, an interpolated point is added at that boundary (see Get(..)). Boundaries
+//outside the timeseries points are not extrapolated
+func (t *Timeseries) ValuesRangeInterpolated(from time.Time, to time.Time) (timeValues []TimeValue, values []float64) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	vs, _ := t.valuesRange(from, to)
+	if from.Before(to) || from.Equal(to) {
+		if tv, ok := t.get(from); ok && (len(vs) == 0 || vs[0].Time.After(from)) {
+			vs = append([]TimeValue{tv}, vs...)
+		}
+		if tv, ok := t.get(to); ok && vs[len(vs)-1].Time.Before(to) {
+			vs = append(vs, tv)
+		}
+	}
+	values = make([]float64, len(vs))
+	for i, v := range vs {
+		values[i] = v.Value
 	}
 	return vs, values
 }
 
-//StdDev calculates the standard deviation and mean for the time range
-//returns standard deviation and mean value
-func (t *Timeseries) StdDev(from time.Time, to time.Time) (std float64, mean float64) {
+//StdDev calculates the (sample) standard deviation and mean for the points in time range
+//Boundary points are not interpolated (see ValuesRange(..))
+//returns standard deviation and mean value. ok is false if there are less than two points in range
+func (t *Timeseries) StdDev(from time.Time, to time.Time) (std float64, mean float64, ok bool) {
 	t.m.RLock()
 	defer t.m.RUnlock()
 	_, values := t.valuesRange(from, to)
+	if len(values) < 2 {
+		return 0, 0, false
+	}
 	mean, std = stat.MeanStdDev(values, nil)
-	return std, mean
+	return std, mean, true
 }
 
-//LinearRegression calculates the linear regression coeficients for the time range
+//LinearRegression calculates the linear regression coeficients for the points in time range
+//Boundary points are not interpolated (see ValuesRange(..))
 //x is in range of time.UnixNano()
-//returns alpha and beta as for y = alpha + beta*x and rsquared with fit from 0-1
-func (t *Timeseries) LinearRegression(from time.Time, to time.Time) (alpha float64, beta float64, rsquared float64) {
+//returns alpha and beta as for y = alpha + beta*x and rsquared with fit from 0-1. If all points have the same value, rsquared is 1
+//ok is false if there are less than two points in range
+func (t *Timeseries) LinearRegression(from time.Time, to time.Time) (alpha float64, beta float64, rsquared float64, ok bool) {
 	t.m.RLock()
 	defer t.m.RUnlock()
 	vs, _ := t.valuesRange(from, to)
+	if len(vs) < 2 {
+		return 0, 0, 0, false
+	}
 	x := make([]float64, 0)
 	y := make([]float64, 0)
 	for _, v := range vs {
@@ -204,5 +407,9 @@ func (t *Timeseries) LinearRegression(from time.Time, to time.Time) (alpha float
 	}
 	alpha, beta = stat.LinearRegression(x, y, nil, false)
 	rsquared = stat.RSquared(x, y, nil, alpha, beta)
-	return alpha, beta, rsquared
+	if math.IsNaN(rsquared) {
+		//no variance in y. the horizontal line is a perfect fit
+		rsquared = 1
+	}
+	return alpha, beta, rsquared, true
 }
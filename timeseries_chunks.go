@@ -0,0 +1,415 @@
+package signalutils
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//compressedChunkSize number of points per compressed chunk. The same as Prometheus uses
+const compressedChunkSize = 120
+
+//bitStream append only stream of bits
+type bitStream struct {
+	data []byte
+	bits int
+}
+
+func (b *bitStream) writeBit(bit bool) {
+	if b.bits%8 == 0 {
+		b.data = append(b.data, 0)
+	}
+	if bit {
+		b.data[len(b.data)-1] |= 1 << uint(7-b.bits%8)
+	}
+	b.bits = b.bits + 1
+}
+
+//writeBits writes the 'n' least significant bits of 'v', most significant first
+func (b *bitStream) writeBits(v uint64, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *bitReader) readBit() bool {
+	bit := r.data[r.pos/8]&(1<<uint(7-r.pos%8)) != 0
+	r.pos = r.pos + 1
+	return bit
+}
+
+func (r *bitReader) readBits(n int) uint64 {
+	v := uint64(0)
+	for i := 0; i < n; i++ {
+		v = v << 1
+		if r.readBit() {
+			v = v | 1
+		}
+	}
+	return v
+}
+
+//dodBuckets bit sizes used for encoding timestamp delta of deltas (in nanoseconds)
+var dodBuckets = []int{14, 17, 20, 32}
+
+//gorillaChunk points compressed as described in "Gorilla: A Fast, Scalable, In-Memory Time Series Database" (Pelkonen et al).
+//Timestamps are stored as delta of deltas and values are XORed with the previous value,
+//so regularly spaced points with slowly changing values use just a few bits each
+type gorillaChunk struct {
+	stream    bitStream
+	count     int
+	first     TimeValue
+	last      TimeValue
+	lastDelta int64
+	leading   int
+	trailing  int
+}
+
+func newGorillaChunk(points []TimeValue) *gorillaChunk {
+	c := &gorillaChunk{leading: -1}
+	for _, p := range points {
+		c.append(p)
+	}
+	return c
+}
+
+func (c *gorillaChunk) append(tv TimeValue) {
+	t := tv.Time.UnixNano()
+	v := math.Float64bits(tv.Value)
+	if c.count == 0 {
+		c.stream.writeBits(uint64(t), 64)
+		c.stream.writeBits(v, 64)
+		c.first = tv
+	} else {
+		delta := t - c.last.Time.UnixNano()
+		c.writeDod(delta - c.lastDelta)
+		c.lastDelta = delta
+		c.writeXOR(v ^ math.Float64bits(c.last.Value))
+	}
+	c.last = tv
+	c.count = c.count + 1
+}
+
+//writeDod writes the delta of delta with a prefix code: 0 for zero, 10, 110, 1110 and 11110
+//for each of the dodBuckets sizes and 11111 for a full 64 bits value
+func (c *gorillaChunk) writeDod(dod int64) {
+	if dod == 0 {
+		c.stream.writeBit(false)
+		return
+	}
+	c.stream.writeBit(true)
+	for _, n := range dodBuckets {
+		if dod >= -(1<<uint(n-1)) && dod < 1<<uint(n-1) {
+			c.stream.writeBit(false)
+			c.stream.writeBits(uint64(dod), n)
+			return
+		}
+		c.stream.writeBit(true)
+	}
+	c.stream.writeBits(uint64(dod), 64)
+}
+
+func (c *gorillaChunk) writeXOR(x uint64) {
+	if x == 0 {
+		c.stream.writeBit(false)
+		return
+	}
+	c.stream.writeBit(true)
+	leading := bits.LeadingZeros64(x)
+	trailing := bits.TrailingZeros64(x)
+	if leading > 31 {
+		leading = 31
+	}
+	if c.leading != -1 && leading >= c.leading && trailing >= c.trailing {
+		//meaningful bits fit in the previous window
+		c.stream.writeBit(false)
+		c.stream.writeBits(x>>uint(c.trailing), 64-c.leading-c.trailing)
+		return
+	}
+	c.leading = leading
+	c.trailing = trailing
+	c.stream.writeBit(true)
+	c.stream.writeBits(uint64(leading), 5)
+	//64 significant bits is written as 0, as it doesn't fit in 6 bits
+	significant := 64 - leading - trailing
+	c.stream.writeBits(uint64(significant%64), 6)
+	c.stream.writeBits(x>>uint(trailing), significant)
+}
+
+//iterator decodes the points of this chunk lazily, one at a time
+func (c *gorillaChunk) iterator(loc *time.Location) *chunkIterator {
+	return &chunkIterator{
+		chunk:  c,
+		reader: bitReader{data: c.stream.data},
+		loc:    loc,
+	}
+}
+
+//points decodes all points of this chunk
+func (c *gorillaChunk) points(loc *time.Location) []TimeValue {
+	ps := make([]TimeValue, 0, c.count)
+	it := c.iterator(loc)
+	for it.next() {
+		ps = append(ps, it.at())
+	}
+	return ps
+}
+
+type chunkIterator struct {
+	chunk    *gorillaChunk
+	reader   bitReader
+	loc      *time.Location
+	read     int
+	t        int64
+	delta    int64
+	v        uint64
+	leading  int
+	trailing int
+}
+
+//next decodes the next point. Returns false if there are no more points
+func (it *chunkIterator) next() bool {
+	if it.read >= it.chunk.count {
+		return false
+	}
+	if it.read == 0 {
+		it.t = int64(it.reader.readBits(64))
+		it.v = it.reader.readBits(64)
+	} else {
+		it.delta = it.delta + it.readDod()
+		it.t = it.t + it.delta
+		it.v = it.v ^ it.readXOR()
+	}
+	it.read = it.read + 1
+	return true
+}
+
+func (it *chunkIterator) at() TimeValue {
+	return TimeValue{time.Unix(0, it.t).In(it.loc), math.Float64frombits(it.v)}
+}
+
+func (it *chunkIterator) readDod() int64 {
+	if !it.reader.readBit() {
+		return 0
+	}
+	for _, n := range dodBuckets {
+		if !it.reader.readBit() {
+			return signExtend(it.reader.readBits(n), n)
+		}
+	}
+	return int64(it.reader.readBits(64))
+}
+
+func (it *chunkIterator) readXOR() uint64 {
+	if !it.reader.readBit() {
+		return 0
+	}
+	if it.reader.readBit() {
+		it.leading = int(it.reader.readBits(5))
+		significant := int(it.reader.readBits(6))
+		if significant == 0 {
+			significant = 64
+		}
+		it.trailing = 64 - it.leading - significant
+	}
+	return it.reader.readBits(64-it.leading-it.trailing) << uint(it.trailing)
+}
+
+func signExtend(v uint64, n int) int64 {
+	shift := uint(64 - n)
+	return int64(v<<shift) >> shift
+}
+
+//chunkStore storage of time ordered points in compressed chunks. Points are appended to the last chunk
+//and a new chunk is started when it is full. Changes to points in the middle re-encode the affected chunk only
+type chunkStore struct {
+	chunks []*gorillaChunk
+	//offsets index of the first point of each chunk
+	offsets []int
+	count   int
+	loc     *time.Location
+	//shared chunks might be in use by an iterator, so they must be copied before being changed
+	//It is set atomically by iterators, which only hold the read lock of the timeseries
+	shared int32
+}
+
+func newChunkStore() *chunkStore {
+	return &chunkStore{
+		chunks:  make([]*gorillaChunk, 0),
+		offsets: make([]int, 0),
+		loc:     time.Local,
+	}
+}
+
+func (s *chunkStore) size() int {
+	return s.count
+}
+
+//bytes approximate memory used by the compressed points
+func (s *chunkStore) bytes() int {
+	b := 0
+	for _, c := range s.chunks {
+		b = b + len(c.stream.data)
+	}
+	return b
+}
+
+func (s *chunkStore) last() (TimeValue, bool) {
+	if s.count == 0 {
+		return TimeValue{}, false
+	}
+	return s.chunks[len(s.chunks)-1].last, true
+}
+
+//chunkIndex index of the chunk that contains point 'i'
+func (s *chunkStore) chunkIndex(i int) int {
+	return sort.Search(len(s.offsets), func(k int) bool {
+		return s.offsets[k] > i
+	}) - 1
+}
+
+func (s *chunkStore) at(i int) TimeValue {
+	ci := s.chunkIndex(i)
+	it := s.chunks[ci].iterator(s.loc)
+	for j := s.offsets[ci]; j <= i; j++ {
+		it.next()
+	}
+	return it.at()
+}
+
+//points decodes points in indexes [i1:i2]
+func (s *chunkStore) points(i1 int, i2 int) []TimeValue {
+	if i2 <= i1 {
+		return make([]TimeValue, 0)
+	}
+	ps := make([]TimeValue, 0, i2-i1)
+	i := s.offsets[s.chunkIndex(i1)]
+	for ci := s.chunkIndex(i1); ci < len(s.chunks) && i < i2; ci++ {
+		it := s.chunks[ci].iterator(s.loc)
+		for ; i < i2 && it.next(); i++ {
+			if i >= i1 {
+				ps = append(ps, it.at())
+			}
+		}
+	}
+	return ps
+}
+
+//search index of the first point for which 'f' is true (or size() if none), given that
+//once 'f' is true for a point, it is true for all points after it
+func (s *chunkStore) search(f func(TimeValue) bool) int {
+	ci := sort.Search(len(s.chunks), func(k int) bool {
+		return f(s.chunks[k].last)
+	})
+	if ci == len(s.chunks) {
+		return s.count
+	}
+	i := s.offsets[ci]
+	it := s.chunks[ci].iterator(s.loc)
+	for it.next() && !f(it.at()) {
+		i = i + 1
+	}
+	return i
+}
+
+//unshare copies the chunks list and the last chunk (the only one changed in place) if they might be in use by an iterator
+func (s *chunkStore) unshare() {
+	if atomic.LoadInt32(&s.shared) == 0 {
+		return
+	}
+	s.chunks = append(make([]*gorillaChunk, 0, len(s.chunks)+1), s.chunks...)
+	s.offsets = append(make([]int, 0, len(s.offsets)+1), s.offsets...)
+	if len(s.chunks) > 0 {
+		last := *s.chunks[len(s.chunks)-1]
+		last.stream.data = append(make([]byte, 0, len(last.stream.data)+8), last.stream.data...)
+		s.chunks[len(s.chunks)-1] = &last
+	}
+	atomic.StoreInt32(&s.shared, 0)
+}
+
+func (s *chunkStore) append(tv TimeValue) {
+	s.unshare()
+	if s.count == 0 {
+		s.loc = tv.Time.Location()
+	}
+	if len(s.chunks) == 0 || s.chunks[len(s.chunks)-1].count >= compressedChunkSize {
+		s.chunks = append(s.chunks, newGorillaChunk(nil))
+		s.offsets = append(s.offsets, s.count)
+	}
+	s.chunks[len(s.chunks)-1].append(tv)
+	s.count = s.count + 1
+}
+
+//insert adds a point at index 'i', re-encoding the chunk it belongs to
+//A chunk that gets more than compressedChunkSize points is split in two halves, so that
+//repeated inserts in the same range don't make a chunk grow (and get slower to re-encode) without limit
+func (s *chunkStore) insert(i int, tv TimeValue) {
+	if i == s.count {
+		s.append(tv)
+		return
+	}
+	s.unshare()
+	ci := s.chunkIndex(i)
+	ps := s.chunks[ci].points(s.loc)
+	j := i - s.offsets[ci]
+	ps = append(ps, TimeValue{})
+	copy(ps[j+1:], ps[j:])
+	ps[j] = tv
+	for k := ci + 1; k < len(s.offsets); k++ {
+		s.offsets[k] = s.offsets[k] + 1
+	}
+	s.count = s.count + 1
+	if len(ps) <= compressedChunkSize {
+		s.chunks[ci] = newGorillaChunk(ps)
+		return
+	}
+	h := len(ps) / 2
+	s.chunks[ci] = newGorillaChunk(ps[:h])
+	s.chunks = append(s.chunks, nil)
+	copy(s.chunks[ci+2:], s.chunks[ci+1:])
+	s.chunks[ci+1] = newGorillaChunk(ps[h:])
+	s.offsets = append(s.offsets, 0)
+	copy(s.offsets[ci+2:], s.offsets[ci+1:])
+	s.offsets[ci+1] = s.offsets[ci] + h
+}
+
+//set changes the value of point 'i', re-encoding the chunk it belongs to
+func (s *chunkStore) set(i int, value float64) {
+	s.unshare()
+	ci := s.chunkIndex(i)
+	ps := s.chunks[ci].points(s.loc)
+	ps[i-s.offsets[ci]].Value = value
+	s.chunks[ci] = newGorillaChunk(ps)
+}
+
+//dropFirst removes the 'n' oldest points
+func (s *chunkStore) dropFirst(n int) {
+	if n <= 0 {
+		return
+	}
+	if n >= s.count {
+		*s = *newChunkStore()
+		return
+	}
+	s.unshare()
+	ci := s.chunkIndex(n)
+	chunks := s.chunks[ci:]
+	if j := n - s.offsets[ci]; j > 0 {
+		chunks[0] = newGorillaChunk(chunks[0].points(s.loc)[j:])
+	}
+	s.chunks = make([]*gorillaChunk, len(chunks))
+	copy(s.chunks, chunks)
+	s.offsets = make([]int, len(chunks))
+	s.count = 0
+	for k, c := range s.chunks {
+		s.offsets[k] = s.count
+		s.count = s.count + c.count
+	}
+}
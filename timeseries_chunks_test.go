@@ -0,0 +1,198 @@
+package signalutils
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGorillaChunkRoundTrip(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := rand.New(rand.NewSource(1))
+	points := make([]TimeValue, 0)
+	when := start
+	values := []float64{0, 1, 1, -1, math.Inf(1), math.Inf(-1), math.MaxFloat64, math.SmallestNonzeroFloat64, 123.456}
+	for i := 0; i < 500; i++ {
+		//regular, jittered, big gaps and repeated deltas
+		switch i % 5 {
+		case 0:
+			when = when.Add(time.Duration(r.Int63n(int64(100 * time.Hour))))
+		case 1:
+			when = when.Add(time.Duration(r.Int63n(1000)) + 1)
+		default:
+			when = when.Add(1 * time.Second)
+		}
+		v := r.NormFloat64() * 1000
+		if i < len(values) {
+			v = values[i]
+		}
+		points = append(points, TimeValue{when, v})
+	}
+
+	c := newGorillaChunk(points)
+	assert.Equal(t, points, c.points(time.UTC))
+
+	//NaN can't be compared with assert.Equal
+	c = newGorillaChunk([]TimeValue{{start, 1}, {start.Add(1 * time.Second), math.NaN()}, {start.Add(2 * time.Second), 2}})
+	ps := c.points(time.UTC)
+	assert.True(t, math.IsNaN(ps[1].Value))
+	assert.Equal(t, 2.0, ps[2].Value)
+}
+
+func TestGorillaChunkCompression(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newChunkStore()
+	for i := 0; i < 1200; i++ {
+		s.append(TimeValue{start.Add(time.Duration(i) * time.Second), float64(i % 10)})
+	}
+	assert.Equal(t, 1200, s.size())
+	assert.Equal(t, 10, len(s.chunks))
+	//a raw TimeValue uses 32 bytes
+	assert.Less(t, s.bytes(), 1200*32/5)
+}
+
+func TestChunkStoreChanges(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newChunkStore()
+	expected := make([]TimeValue, 0)
+	for i := 0; i < 300; i++ {
+		tv := TimeValue{start.Add(time.Duration(2*i) * time.Second), float64(i)}
+		s.append(tv)
+		expected = append(expected, tv)
+	}
+	assert.Equal(t, expected[130], s.at(130))
+	assert.Equal(t, expected[119:125], s.points(119, 125))
+
+	//insert in the middle of the second chunk
+	tv := TimeValue{start.Add(301 * time.Second), -1}
+	s.insert(151, tv)
+	expected = append(expected[:151], append([]TimeValue{tv}, expected[151:]...)...)
+	assert.Equal(t, expected, s.points(0, s.size()))
+	assert.Equal(t, expected[250], s.at(250))
+
+	s.set(10, 99)
+	expected[10].Value = 99
+	assert.Equal(t, expected, s.points(0, s.size()))
+
+	s.dropFirst(125)
+	expected = expected[125:]
+	assert.Equal(t, expected, s.points(0, s.size()))
+	assert.Equal(t, 176, s.size())
+	//the second chunk was split in two halves by the insert
+	assert.Equal(t, 3, len(s.chunks))
+
+	i := s.search(func(tv TimeValue) bool {
+		return !tv.Time.Before(start.Add(400 * time.Second))
+	})
+	assert.Equal(t, start.Add(400*time.Second), s.at(i).Time)
+
+	s.dropFirst(1000)
+	assert.Equal(t, 0, s.size())
+	_, ok := s.last()
+	assert.False(t, ok)
+}
+
+func TestChunkStoreInsertSplit(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newChunkStore()
+	expected := make([]TimeValue, 0)
+	for i := 0; i < 200; i++ {
+		tv := TimeValue{start.Add(time.Duration(i) * time.Hour), float64(i)}
+		s.append(tv)
+		expected = append(expected, tv)
+	}
+
+	//many inserts in the range of the first chunk
+	for i := 0; i < 500; i++ {
+		tv := TimeValue{start.Add(time.Duration(i+1) * time.Second), float64(-i)}
+		s.insert(i+1, tv)
+		expected = append(expected[:i+1], append([]TimeValue{tv}, expected[i+1:]...)...)
+	}
+	assert.Equal(t, expected, s.points(0, s.size()))
+	assert.Equal(t, 700, s.size())
+	count := 0
+	for k, c := range s.chunks {
+		assert.LessOrEqual(t, c.count, compressedChunkSize)
+		assert.Equal(t, count, s.offsets[k])
+		count = count + c.count
+	}
+	assert.Equal(t, expected[350], s.at(350))
+
+	//appends still go to the last chunk
+	tv := TimeValue{start.Add(1000 * time.Hour), 1}
+	s.insert(s.size(), tv)
+	expected = append(expected, tv)
+	assert.Equal(t, expected, s.points(0, s.size()))
+}
+
+func TestCompressedTimeseries(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	raw := NewTimeseriesWithClock(1*time.Hour, c)
+	ts := NewCompressedTimeseriesWithClock(1*time.Hour, c)
+	for i := 0; i < 1000; i++ {
+		v := math.Sin(float64(i) / 10)
+		when := start.Add(time.Duration(i) * time.Second)
+		raw.AddWithTime(v, when)
+		ts.AddWithTime(v, when)
+	}
+	assert.Equal(t, 0, len(ts.Values))
+	assert.Equal(t, 1000, ts.Size())
+
+	l, ok := ts.Last()
+	assert.True(t, ok)
+	rl, _ := raw.Last()
+	assert.Equal(t, rl, l)
+
+	for _, d := range []time.Duration{0, 1500 * time.Millisecond, 333 * time.Second, 999 * time.Second} {
+		v, ok := ts.Get(start.Add(d))
+		assert.True(t, ok)
+		rv, _ := raw.Get(start.Add(d))
+		assert.Equal(t, rv, v)
+	}
+	_, ok = ts.Get(start.Add(-1 * time.Second))
+	assert.False(t, ok)
+
+	avg, ok := ts.Avg(start.Add(100*time.Second), start.Add(700*time.Second))
+	assert.True(t, ok)
+	ravg, _ := raw.Avg(start.Add(100*time.Second), start.Add(700*time.Second))
+	assert.Equal(t, ravg, avg)
+
+	vs, _ := ts.ValuesRange(start.Add(118*time.Second), start.Add(122*time.Second))
+	rvs, _ := raw.ValuesRange(start.Add(118*time.Second), start.Add(122*time.Second))
+	assert.Equal(t, rvs, vs)
+
+	r, _ := ts.Resample(start, start.Add(20*time.Second), 5*time.Second, ResampleMean)
+	rr, _ := raw.Resample(start, start.Add(20*time.Second), 5*time.Second, ResampleMean)
+	rvs, _ = rr.ValuesRange(start, start.Add(20*time.Second))
+	vs, _ = r.ValuesRange(start, start.Add(20*time.Second))
+	assert.Equal(t, 5, len(vs))
+	assert.Equal(t, rvs, vs)
+}
+
+func TestCompressedTimeseriesPolicies(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewCompressedTimeseriesWithClock(5*time.Second, c)
+	ts.SetInsertPolicy(InsertPolicy{OutOfOrder: OutOfOrderInsert, Tolerance: 1 * time.Minute, Duplicate: DuplicateSum})
+	ts.SetRetentionPolicy(RetentionPolicy{Reference: RetentionLastSample})
+	for i := 0; i <= 10; i++ {
+		ts.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Nil(t, ts.AddWithTime(100, start.Add(8500*time.Millisecond)))
+	assert.Nil(t, ts.AddWithTime(100, start.Add(9*time.Second)))
+	vs, _ := ts.ValuesRange(start, start.Add(1*time.Minute))
+	values := make([]float64, 0)
+	for _, v := range vs {
+		values = append(values, v.Value)
+	}
+	assert.Equal(t, []float64{3, 4, 5, 6, 7, 8, 100, 109, 10}, values)
+
+	ts.SetRetentionPolicy(RetentionPolicy{MaxPoints: 2})
+	assert.Equal(t, 2, ts.Size())
+	ts.Reset()
+	assert.Equal(t, 0, ts.Size())
+}
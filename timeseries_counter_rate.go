@@ -13,16 +13,24 @@ import (
 //a counter, so that averages between two times are calculated by just
 //averaging the first and last points, not all the points between.
 //Very useful for metrics monitoring. See more at https://prometheus.io/docs/concepts/metric_types/#counter
+//As in Prometheus, any decrease of the counter value is handled as a counter reset (process restarted, for example),
+//so that rates and increases are calculated as if the counter had continued from the value before the reset
 //Only initialize this with NewTimeseries(..)
 type TimeseriesCounterRate struct {
 	Timeseries Timeseries
 	ccounter   float64
+	strict     bool
 	m          *sync.RWMutex
 }
 
 //NewTimeseriesCounterRate creates a time timeseries with max time span of timeseriesSpan
 func NewTimeseriesCounterRate(timeseriesSpan time.Duration) TimeseriesCounterRate {
-	ts := NewTimeseries(timeseriesSpan)
+	return NewTimeseriesCounterRateWithClock(timeseriesSpan, SystemClock)
+}
+
+//NewTimeseriesCounterRateWithClock same as NewTimeseriesCounterRate(..), but using 'clock' as time source
+func NewTimeseriesCounterRateWithClock(timeseriesSpan time.Duration, clock Clock) TimeseriesCounterRate {
+	ts := NewTimeseriesWithClock(timeseriesSpan, clock)
 	return TimeseriesCounterRate{
 		Timeseries: ts,
 		m:          &sync.RWMutex{},
@@ -30,7 +38,9 @@ func NewTimeseriesCounterRate(timeseriesSpan time.Duration) TimeseriesCounterRat
 }
 
 //Inc increments the last value from the timeseries by 'value' and sets
-//add the new point with time.Now() time
+//add the new point with current clock time
+//If the point is dropped (for example, when there is already a point at current clock time), the increment
+//is still counted and will be part of the next point
 func (t *TimeseriesCounterRate) Inc(value float64) error {
 	t.m.Lock()
 	defer t.m.Unlock()
@@ -42,18 +52,61 @@ func (t *TimeseriesCounterRate) Inc(value float64) error {
 	return nil
 }
 
-//Set sets the absolute value at time time.Now(). The value cannot be less
-//then last value from the timeseries as this must be a counter
+//IncWithTime same as Inc(..), but the new point is added at time 'when'. Useful for backfilling counter samples
+//'when' must be after the last point, unless allowed by the timeseries insert policy (see Timeseries.SetInsertPolicy(..))
+//Differently from Inc(..), an error is returned if the point is dropped and the increment is not counted
+func (t *TimeseriesCounterRate) IncWithTime(value float64, when time.Time) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if value < 0 {
+		return fmt.Errorf("value cannot be negative")
+	}
+	return t.add(t.ccounter+value, when)
+}
+
+//Set sets the absolute value at current clock time. If the value is less than
+//the current counter, it is handled as a counter reset, unless in strict mode (see SetStrict(..))
+//Returns an error if the point is dropped by the insert policy (for example, when there is already
+//a point at current clock time), in which case the current counter is not changed
 func (t *TimeseriesCounterRate) Set(value float64) error {
+	return t.SetWithTime(value, t.Timeseries.clock.Now())
+}
+
+//SetWithTime same as Set(..), but the new point is added at time 'when'. Useful for backfilling scraped counter samples
+//with their original timestamps. See IncWithTime(..)
+func (t *TimeseriesCounterRate) SetWithTime(value float64, when time.Time) error {
 	t.m.Lock()
 	defer t.m.Unlock()
-	if value < t.ccounter {
+	if value < 0 {
+		return fmt.Errorf("value cannot be negative")
+	}
+	if t.strict && value < t.ccounter {
 		return fmt.Errorf("value cannot be less than current counter")
 	}
-	t.Timeseries.Add(value)
+	return t.add(value, when)
+}
+
+//add adds the point and updates the current counter to the value of the last point
+//(which may not be the added one for out of order points)
+func (t *TimeseriesCounterRate) add(value float64, when time.Time) error {
+	err := t.Timeseries.AddWithTime(value, when)
+	if err != nil {
+		return err
+	}
+	if l, ok := t.Timeseries.Last(); ok {
+		t.ccounter = l.Value
+	}
 	return nil
 }
 
+//SetStrict in strict mode Set(..) returns an error if the value is less than the current counter
+//instead of handling it as a counter reset
+func (t *TimeseriesCounterRate) SetStrict(strict bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.strict = strict
+}
+
 //Rate calculates the rate of change between the last point in time of this timeseries
 //and the time in past, specified by timeSpan
 func (t *TimeseriesCounterRate) Rate(timeSpan time.Duration) (float64, bool) {
@@ -70,6 +123,44 @@ func (t *TimeseriesCounterRate) Rate(timeSpan time.Duration) (float64, bool) {
 	return t.rateRange(n.Add(-timeSpan), n)
 }
 
+//ExtrapolatedRate calculates the per second rate of change in the last 'timeSpan' until now just like Prometheus'
+//rate() function, extrapolating the increase between the first and last points to the boundaries of the window.
+//Differently from Rate(..), results are available as soon as there are two points in the window
+func (t *TimeseriesCounterRate) ExtrapolatedRate(timeSpan time.Duration) (float64, bool) {
+	return t.extrapolated(timeSpan, true)
+}
+
+//Increase calculates how much the counter increased in the last 'timeSpan' until now just like Prometheus'
+//increase() function. See ExtrapolatedRate(..)
+func (t *TimeseriesCounterRate) Increase(timeSpan time.Duration) (float64, bool) {
+	return t.extrapolated(timeSpan, false)
+}
+
+func (t *TimeseriesCounterRate) extrapolated(timeSpan time.Duration, perSecond bool) (float64, bool) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	if timeSpan <= 0 || timeSpan > t.Timeseries.TimeseriesSpan {
+		return 0, false
+	}
+	to := t.Timeseries.clock.Now()
+	from := to.Add(-timeSpan)
+	return extrapolatedDelta(pointsBetween(&t.Timeseries, from, to), from, to, true, perSecond)
+}
+
+//IRate calculates the per second instant rate between the last two points, just like Prometheus' irate() function
+func (t *TimeseriesCounterRate) IRate() (float64, bool) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	ts := &t.Timeseries
+	ts.m.RLock()
+	defer ts.m.RUnlock()
+	n := ts.size()
+	if n < 2 {
+		return 0, false
+	}
+	return instantRate(ts.points(n-2, n), nil, 0)
+}
+
 //RateRange calculate the rate of change in the date range
 func (t *TimeseriesCounterRate) RateRange(from time.Time, to time.Time) (float64, bool) {
 	t.m.RLock()
@@ -77,20 +168,53 @@ func (t *TimeseriesCounterRate) RateRange(from time.Time, to time.Time) (float64
 	return t.rateRange(from, to)
 }
 func (t *TimeseriesCounterRate) rateRange(from time.Time, to time.Time) (float64, bool) {
-	v1, ok := t.Timeseries.Get(from)
+	vd, ok := t.increaseRange(from, to)
 	if !ok {
 		return 0, false
 	}
+	td := float64(to.UnixNano()-from.UnixNano()) / 1000000000
+	return vd / td, true
+}
 
-	v2, ok := t.Timeseries.Get(to)
+//IncreaseRange calculates how much the counter increased in the date range, compensating counter resets
+func (t *TimeseriesCounterRate) IncreaseRange(from time.Time, to time.Time) (float64, bool) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.increaseRange(from, to)
+}
+func (t *TimeseriesCounterRate) increaseRange(from time.Time, to time.Time) (float64, bool) {
+	ts := &t.Timeseries
+	ts.m.RLock()
+	defer ts.m.RUnlock()
+	i1, _, ok := ts.pos(from)
 	if !ok {
 		return 0, false
 	}
+	_, i2, ok := ts.pos(to)
+	if !ok || i2 < i1 {
+		return 0, false
+	}
 
-	td := float64(v2.Time.UnixNano()-v1.Time.UnixNano()) / 1000000000
-	vd := v2.Value - v1.Value
+	//values after each reset are offset by the value before the reset so that the counter never decreases
+	raw := ts.points(i1, i2+1)
+	ps := make([]TimeValue, len(raw))
+	offset := 0.0
+	for i, p := range raw {
+		if i > 0 && p.Value < raw[i-1].Value {
+			offset = offset + raw[i-1].Value
+		}
+		ps[i] = TimeValue{p.Time, p.Value + offset}
+	}
 
-	return vd / td, true
+	v1 := ps[0].Value
+	if len(ps) > 1 {
+		v1 = interpolate(ps[0], ps[1], from)
+	}
+	v2 := ps[len(ps)-1].Value
+	if len(ps) > 1 {
+		v2 = interpolate(ps[len(ps)-2], ps[len(ps)-1], to)
+	}
+	return v2 - v1, true
 }
 
 //RateOverTime calculates a new Timeseries containing rate over time which each value is a rate over 'rateLen'
@@ -100,17 +224,17 @@ func (t *TimeseriesCounterRate) rateRange(from time.Time, to time.Time) (float64
 func (t *TimeseriesCounterRate) RateOverTime(rateLen time.Duration, timeseriesSpan time.Duration) (ts Timeseries, ok bool) {
 	t.m.RLock()
 	defer t.m.RUnlock()
-	to := time.Now()
+	to := t.Timeseries.clock.Now()
 	from := to.Add(-timeseriesSpan)
-	rateTs := NewTimeseries(timeseriesSpan + rateLen)
-	for _, v := range t.Timeseries.Values {
-		if (v.Time == from || v.Time.After(from)) && (v.Time == to || v.Time.Before(to)) {
-			rv, ok := t.rateRange(v.Time.Add(-rateLen), v.Time)
-			if !ok {
-				continue
-			}
-			rateTs.AddWithTime(rv, v.Time)
+	rateTs := NewTimeseriesWithClock(timeseriesSpan+rateLen, t.Timeseries.clock)
+	it := t.Timeseries.Iterator()
+	for more := it.Seek(from); more && !it.At().Time.After(to); more = it.Next() {
+		v := it.At()
+		rv, ok := t.rateRange(v.Time.Add(-rateLen), v.Time)
+		if !ok {
+			continue
 		}
+		rateTs.AddWithTime(rv, v.Time)
 	}
 	return rateTs, true
 }
@@ -8,69 +8,79 @@ import (
 )
 
 func TestRate1(t *testing.T) {
-	ts := NewTimeseriesCounterRate(5 * time.Second)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(5*time.Second, c)
 
 	ts.Inc(20) //20
-	time.Sleep(1 * time.Second)
+	c.Advance(1 * time.Second)
 
 	ts.Inc(30) //50
-	time.Sleep(2 * time.Second)
+	c.Advance(2 * time.Second)
 
 	ts.Inc(150) //200
-	time.Sleep(1 * time.Second)
+	c.Advance(1 * time.Second)
 
 	r, ok := ts.Rate(2 * time.Second)
 	assert.True(t, ok)
-	assert.InDeltaf(t, float64(75), r, float64(10), "")
+	assert.Equal(t, float64(75), r)
+
+	rt, ok := ts.RateOverTime(1*time.Second, 4*time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 2, rt.Size())
+	assert.Equal(t, 30.0, rt.Values[0].Value)
+	assert.Equal(t, 75.0, rt.Values[1].Value)
 }
 
 func TestRate2(t *testing.T) {
-	ts := NewTimeseriesCounterRate(3 * time.Second)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(3*time.Second, c)
 
 	ts.Inc(100000) //100000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(100000) //200000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(200000) //400000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(100000) //500000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 
 	r, ok := ts.Rate(750 * time.Millisecond)
 	assert.True(t, ok)
-	assert.InDeltaf(t, float64(466666), r, float64(10000), "")
+	assert.InDeltaf(t, float64(466666), r, float64(1), "")
 }
 
 func TestRate3(t *testing.T) {
-	ts := NewTimeseriesCounterRate(1 * time.Second)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(1*time.Second, c)
 
 	_, ok := ts.Rate(1 * time.Second)
 	assert.False(t, ok)
 
 	ts.Inc(100000) //100000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(100000) //200000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(200000) //400000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 	ts.Inc(100000) //500000
-	time.Sleep(300 * time.Millisecond)
+	c.Advance(300 * time.Millisecond)
 
 	_, ok = ts.Rate(2 * time.Second)
 	assert.False(t, ok)
 }
 
 func TestRateRange1(t *testing.T) {
-	ts := NewTimeseriesCounterRate(5 * time.Second)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(5*time.Second, c)
 
 	ts.Inc(20) //20
-	time.Sleep(1 * time.Second)
+	c.Advance(1 * time.Second)
 
 	ts.Inc(30) //50
-	time.Sleep(2 * time.Second)
+	c.Advance(2 * time.Second)
 
 	ts.Inc(150) //200
-	time.Sleep(1 * time.Second)
+	c.Advance(1 * time.Second)
 
 	n1, ok := ts.Timeseries.Last()
 	n := n1.Time
@@ -78,35 +88,205 @@ func TestRateRange1(t *testing.T) {
 
 	r, ok := ts.RateRange(n.Add(-1*time.Second), n)
 	assert.True(t, ok)
-	assert.InDeltaf(t, float64(75), r, float64(10), "")
+	assert.Equal(t, float64(75), r)
 
 	r, ok = ts.RateRange(n.Add(-2*time.Second), n)
 	assert.True(t, ok)
-	assert.InDeltaf(t, float64(75), r, float64(10), "")
+	assert.Equal(t, float64(75), r)
 }
 
 func TestRateOverTime1(t *testing.T) {
-	ts := NewTimeseriesCounterRate(5 * time.Second)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(5*time.Second, c)
 
 	ts.Inc(10) //10
-	time.Sleep(500 * time.Millisecond)
+	c.Advance(500 * time.Millisecond)
 	ts.Inc(10) //20
-	time.Sleep(500 * time.Millisecond)
+	c.Advance(500 * time.Millisecond)
 	ts.Inc(20) //40
-	time.Sleep(500 * time.Millisecond)
+	c.Advance(500 * time.Millisecond)
 	ts.Inc(10) //50
-	time.Sleep(500 * time.Millisecond)
+	c.Advance(500 * time.Millisecond)
 
 	rt, ok := ts.RateOverTime(500*time.Millisecond, 2*time.Second)
 	assert.True(t, ok)
 	assert.Equal(t, 3, rt.Size())
 
-	// l, ok := rt.Last()
-	// assert.True(t, ok)
-	// assert.Equal(t, 10.0, l.Value)
+	assert.Equal(t, 20.0, rt.Values[0].Value)
+	assert.Equal(t, 40.0, rt.Values[1].Value)
+	assert.Equal(t, 20.0, rt.Values[2].Value)
+}
+
+func TestCounterReset(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+
+	//0@0s 10@1s 20@2s 5@3s 15@4s 3@5s
+	for _, v := range []float64{0, 10, 20, 5, 15, 3} {
+		err := ts.Set(v)
+		assert.Nil(t, err)
+		c.Advance(1 * time.Second)
+	}
+	assert.Equal(t, 6, ts.Timeseries.Size())
+
+	inc, ok := ts.IncreaseRange(start, start.Add(5*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 38.0, inc)
+
+	inc, ok = ts.IncreaseRange(start.Add(1*time.Second), start.Add(2*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, inc)
+
+	//interpolation across reset
+	inc, ok = ts.IncreaseRange(start.Add(2500*time.Millisecond), start.Add(4*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 12.5, inc)
+
+	r, ok := ts.RateRange(start.Add(2*time.Second), start.Add(4*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 7.5, r)
+
+	r, ok = ts.Rate(2 * time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 6.5, r)
+
+	_, ok = ts.IncreaseRange(start.Add(-1*time.Second), start.Add(2*time.Second))
+	assert.False(t, ok)
+
+	//counter continues from reset value
+	ts.Inc(1)
+	l, _ := ts.Timeseries.Last()
+	assert.Equal(t, 4.0, l.Value)
+
+	err := ts.Set(-1)
+	assert.NotNil(t, err)
+}
+
+func TestCounterStrict(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+	ts.SetStrict(true)
+
+	err := ts.Set(10)
+	assert.Nil(t, err)
+	c.Advance(1 * time.Second)
+	err = ts.Set(5)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, ts.Timeseries.Size())
+
+	ts.SetStrict(false)
+	err = ts.Set(5)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, ts.Timeseries.Size())
+}
+
+func TestCounterExtrapolatedRate(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(5*time.Minute, c)
+
+	_, ok := ts.IRate()
+	assert.False(t, ok)
+	_, ok = ts.Increase(1 * time.Minute)
+	assert.False(t, ok)
+
+	//short lived series: points at 0s, 10s, 20s
+	ts.Set(100)
+	c.Advance(10 * time.Second)
+	ts.Set(110)
+	c.Advance(10 * time.Second)
+	ts.Set(130)
+
+	_, ok = ts.Rate(1 * time.Minute)
+	assert.False(t, ok)
+
+	//extrapolated by half the average interval at the window start
+	inc, ok := ts.Increase(1 * time.Minute)
+	assert.True(t, ok)
+	assert.InDelta(t, 37.5, inc, 1e-9)
+	r, ok := ts.ExtrapolatedRate(1 * time.Minute)
+	assert.True(t, ok)
+	assert.InDelta(t, 37.5/60, r, 1e-9)
+
+	//window boundaries near points are fully extrapolated
+	c.Advance(5 * time.Second)
+	inc, ok = ts.Increase(20 * time.Second)
+	assert.True(t, ok)
+	assert.InDelta(t, 40.0, inc, 1e-9)
+
+	ir, ok := ts.IRate()
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, ir)
+
+	//counter reset
+	c.Advance(5 * time.Second)
+	ts.Set(10)
+	ir, _ = ts.IRate()
+	assert.Equal(t, 1.0, ir)
+	inc, _ = ts.Increase(1 * time.Minute)
+	assert.InDelta(t, 40*35/30.0, inc, 1e-9)
+
+	_, ok = ts.Increase(10 * time.Minute)
+	assert.False(t, ok)
+}
+
+func TestCounterSetAndInc(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+
+	//Inc continues from the value set
+	assert.Nil(t, ts.Set(100))
+	c.Advance(1 * time.Second)
+	assert.Nil(t, ts.Inc(5))
+	l, _ := ts.Timeseries.Last()
+	assert.Equal(t, 105.0, l.Value)
+
+	ts.SetStrict(true)
+	c.Advance(1 * time.Second)
+	assert.NotNil(t, ts.Set(104))
+	assert.Nil(t, ts.Set(105))
+
+	//dropped points don't change the counter on Set
+	assert.NotNil(t, ts.Set(110))
+	assert.NotNil(t, ts.IncWithTime(10, c.Now()))
+	assert.Equal(t, 105.0, ts.ccounter)
+
+	//increments at the same time of the last point are counted in the next point
+	assert.Nil(t, ts.Inc(10))
+	assert.Equal(t, 3, ts.Timeseries.Size())
+	c.Advance(1 * time.Second)
+	assert.Nil(t, ts.Inc(1))
+	l, _ = ts.Timeseries.Last()
+	assert.Equal(t, 116.0, l.Value)
+}
+
+func TestCounterWithTime(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now().Add(-1 * time.Hour)
+	ts := NewTimeseriesCounterRateWithClock(2*time.Hour, c)
+
+	//backfill scraped samples
+	for i, v := range []float64{10, 20, 40} {
+		assert.Nil(t, ts.SetWithTime(v, start.Add(time.Duration(i)*10*time.Second)))
+	}
+	assert.Nil(t, ts.IncWithTime(10, start.Add(30*time.Second)))
+	assert.Equal(t, 4, ts.Timeseries.Size())
+
+	r, ok := ts.Rate(20 * time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 1.5, r)
 
-	assert.InDeltaf(t, 20.0, rt.Values[0].Value, 1.0, "")
-	assert.InDeltaf(t, 40.0, rt.Values[1].Value, 1.0, "")
-	assert.InDeltaf(t, 20.0, rt.Values[2].Value, 1.0, "")
+	assert.NotNil(t, ts.SetWithTime(100, start.Add(30*time.Second)))
+	assert.NotNil(t, ts.IncWithTime(-1, start.Add(40*time.Second)))
 
+	//out of order points don't change the current counter
+	ts.Timeseries.SetInsertPolicy(InsertPolicy{OutOfOrder: OutOfOrderInsert, Tolerance: 1 * time.Minute})
+	assert.Nil(t, ts.SetWithTime(15, start.Add(5*time.Second)))
+	assert.Equal(t, 5, ts.Timeseries.Size())
+	assert.Equal(t, 50.0, ts.ccounter)
+	assert.Nil(t, ts.SetWithTime(45, start.Add(25*time.Second)))
+	assert.Equal(t, 50.0, ts.ccounter)
+	assert.Nil(t, ts.IncWithTime(5, start.Add(40*time.Second)))
+	l, _ := ts.Timeseries.Last()
+	assert.Equal(t, 55.0, l.Value)
 }
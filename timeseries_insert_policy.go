@@ -0,0 +1,91 @@
+package signalutils
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+//ErrPointDropped returned (wrapped) when a point is dropped because it is not allowed by the insert policy.
+//Check for it with errors.Is(..)
+var ErrPointDropped = errors.New("point dropped by insert policy")
+
+//OutOfOrderPolicy what to do with points older than the last point of a Timeseries
+type OutOfOrderPolicy int
+
+const (
+	//OutOfOrderReject points not after the last point are dropped
+	OutOfOrderReject OutOfOrderPolicy = iota
+	//OutOfOrderInsert points no older than InsertPolicy.Tolerance before the last point are inserted in time order.
+	//Older points are dropped
+	OutOfOrderInsert
+)
+
+//DuplicatePolicy what to do with points with the same time as an existing point of a Timeseries
+type DuplicatePolicy int
+
+const (
+	//DuplicateReject the new point is dropped
+	DuplicateReject DuplicatePolicy = iota
+	//DuplicateOverwrite the new value replaces the existing one (the last value added wins)
+	DuplicateOverwrite
+	//DuplicateSum the new value is added to the existing one
+	DuplicateSum
+	//DuplicateMax the greatest of both values is kept
+	DuplicateMax
+)
+
+//InsertPolicy controls how Timeseries.AddWithTime(..) handles points that are not after the last point,
+//which happens when ingesting points from multiple producers with slight clock skews, for example.
+//The zero value rejects both out of order and duplicate points
+type InsertPolicy struct {
+	OutOfOrder OutOfOrderPolicy
+	//Tolerance how much older than the last point an out of order point can be (OutOfOrderInsert only)
+	Tolerance time.Duration
+	Duplicate DuplicatePolicy
+}
+
+//SetInsertPolicy changes how out of order and duplicate points are handled by AddWithTime(..)
+func (t *Timeseries) SetInsertPolicy(policy InsertPolicy) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.insertPolicy = policy
+}
+
+//Dropped number of points that were not added to this timeseries because of its InsertPolicy since it was created
+func (t *Timeseries) Dropped() int64 {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.dropped
+}
+
+//insertIndex finds where a point at 'when' must be placed according to the insert policy
+//duplicate is true if there is already a point at 'when' in index i
+//ok is false if the point must be dropped
+func (t *Timeseries) insertIndex(when time.Time) (i int, duplicate bool, ok bool) {
+	l, exists := t.last()
+	if !exists || l.Time.Before(when) {
+		return t.size(), false, true
+	}
+	if l.Time.Equal(when) {
+		return t.size() - 1, true, true
+	}
+	if t.insertPolicy.OutOfOrder != OutOfOrderInsert || when.Before(l.Time.Add(-t.insertPolicy.Tolerance)) {
+		return -1, false, false
+	}
+	i = t.searchFrom(when)
+	return i, t.at(i).Time.Equal(when), true
+}
+
+//mergeDuplicate applies the duplicate policy (other than DuplicateReject) to the point in index i
+func (t *Timeseries) mergeDuplicate(i int, value float64) {
+	current := t.at(i).Value
+	switch t.insertPolicy.Duplicate {
+	case DuplicateOverwrite:
+		t.set(i, value)
+	case DuplicateSum:
+		t.set(i, current+value)
+	case DuplicateMax:
+		t.set(i, math.Max(current, value))
+	}
+}
@@ -0,0 +1,72 @@
+package signalutils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInsertPolicyDefaultRejects(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	assert.Nil(t, ts.AddWithTime(1, start))
+	//a single point doesn't allow points before it anymore
+	assert.NotNil(t, ts.AddWithTime(2, start.Add(-1*time.Second)))
+	assert.NotNil(t, ts.AddWithTime(3, start))
+	assert.Nil(t, ts.AddWithTime(4, start.Add(1*time.Second)))
+	assert.Equal(t, []float64{1, 4}, tsValues(ts))
+	assert.Equal(t, int64(2), ts.Dropped())
+}
+
+func TestInsertPolicyOutOfOrder(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	ts.SetInsertPolicy(InsertPolicy{OutOfOrder: OutOfOrderInsert, Tolerance: 2 * time.Second})
+	assert.Nil(t, ts.AddWithTime(0, start))
+	assert.Nil(t, ts.AddWithTime(30, start.Add(3*time.Second)))
+	assert.Nil(t, ts.AddWithTime(20, start.Add(2*time.Second)))
+	assert.Nil(t, ts.AddWithTime(10, start.Add(1*time.Second)))
+	//older than tolerance
+	assert.NotNil(t, ts.AddWithTime(5, start.Add(500*time.Millisecond)))
+	//duplicate in the middle
+	assert.NotNil(t, ts.AddWithTime(99, start.Add(2*time.Second)))
+	assert.Equal(t, []float64{0, 10, 20, 30}, tsValues(ts))
+	assert.Equal(t, int64(2), ts.Dropped())
+
+	v, ok := ts.Get(start.Add(1500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 15.0, v.Value)
+}
+
+func TestInsertPolicyDuplicates(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	newTS := func(p InsertPolicy) Timeseries {
+		ts := NewTimeseriesWithClock(1*time.Hour, c)
+		ts.SetInsertPolicy(p)
+		ts.AddWithTime(10, start)
+		ts.AddWithTime(20, start.Add(1*time.Second))
+		return ts
+	}
+
+	ts := newTS(InsertPolicy{Duplicate: DuplicateOverwrite})
+	assert.Nil(t, ts.AddWithTime(5, start.Add(1*time.Second)))
+	assert.Equal(t, []float64{10, 5}, tsValues(ts))
+	//out of order is still rejected
+	assert.NotNil(t, ts.AddWithTime(5, start))
+	assert.Equal(t, int64(1), ts.Dropped())
+
+	ts = newTS(InsertPolicy{Duplicate: DuplicateSum})
+	assert.Nil(t, ts.AddWithTime(5, start.Add(1*time.Second)))
+	assert.Nil(t, ts.AddWithTime(5, start.Add(1*time.Second)))
+	assert.Equal(t, []float64{10, 30}, tsValues(ts))
+
+	ts = newTS(InsertPolicy{Duplicate: DuplicateMax, OutOfOrder: OutOfOrderInsert, Tolerance: 1 * time.Second})
+	assert.Nil(t, ts.AddWithTime(5, start.Add(1*time.Second)))
+	assert.Nil(t, ts.AddWithTime(50, start))
+	assert.Equal(t, []float64{50, 20}, tsValues(ts))
+	assert.Equal(t, int64(0), ts.Dropped())
+}
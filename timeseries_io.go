@@ -0,0 +1,218 @@
+package signalutils
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	//TimeFormatUnix times as (fractional) seconds since epoch. Precision is limited to about a microsecond
+	TimeFormatUnix = "unix"
+	//TimeFormatUnixMilli times as milliseconds since epoch
+	TimeFormatUnixMilli = "unixmilli"
+	//TimeFormatUnixNano times as nanoseconds since epoch
+	TimeFormatUnixNano = "unixnano"
+)
+
+//CSVOptions how points are mapped to CSV columns
+//Use DefaultCSVOptions() for a "time,value" header followed by RFC3339 times and values
+type CSVOptions struct {
+	//TimeColumn index of the column with point times
+	TimeColumn int
+	//ValueColumn index of the column with point values
+	ValueColumn int
+	//TimeFormat layout for time.Format/time.Parse, TimeFormatUnix, TimeFormatUnixMilli or TimeFormatUnixNano. Empty means time.RFC3339Nano
+	TimeFormat string
+	//Location used for parsing times without time zone. nil means UTC
+	Location *time.Location
+	//Header whether there is a header line, which is written on export and skipped on import
+	Header bool
+	//Comma field delimiter. Zero means ','
+	Comma rune
+}
+
+//DefaultCSVOptions options for CSV files with a "time,value" header followed by RFC3339 times and values
+func DefaultCSVOptions() CSVOptions {
+	return CSVOptions{
+		TimeColumn:  0,
+		ValueColumn: 1,
+		Header:      true,
+	}
+}
+
+//WriteCSV writes all points of this timeseries as CSV lines to 'w'
+func (t *Timeseries) WriteCSV(w io.Writer, opts CSVOptions) error {
+	if opts.TimeColumn == opts.ValueColumn || opts.TimeColumn < 0 || opts.ValueColumn < 0 {
+		return fmt.Errorf("time and value columns must be different and not negative")
+	}
+	cw := csv.NewWriter(w)
+	if opts.Comma != 0 {
+		cw.Comma = opts.Comma
+	}
+	record := make([]string, int(math.Max(float64(opts.TimeColumn), float64(opts.ValueColumn)))+1)
+	if opts.Header {
+		record[opts.TimeColumn] = "time"
+		record[opts.ValueColumn] = "value"
+		err := cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	it := t.Iterator()
+	for it.Next() {
+		tv := it.At()
+		record[opts.TimeColumn] = formatTime(tv.Time, opts.TimeFormat)
+		record[opts.ValueColumn] = strconv.FormatFloat(tv.Value, 'g', -1, 64)
+		err := cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+//ReadCSV adds the points of CSV lines from 'r' to this timeseries with AddWithTime(..)
+//Points dropped by the insert policy are counted in Dropped() and don't stop the import.
+//When loading recordings older than TimeseriesSpan, use RetentionLastSample so that they are not pruned right away
+//Returns the number of points added. Stops on the first line that can't be parsed or added for another reason
+//(a failed write to the attached WAL, for example)
+func (t *Timeseries) ReadCSV(r io.Reader, opts CSVOptions) (int, error) {
+	if opts.TimeColumn == opts.ValueColumn || opts.TimeColumn < 0 || opts.ValueColumn < 0 {
+		return 0, fmt.Errorf("time and value columns must be different and not negative")
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	if opts.Comma != 0 {
+		cr.Comma = opts.Comma
+	}
+	added := 0
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return added, nil
+		}
+		if err != nil {
+			return added, err
+		}
+		if line == 1 && opts.Header {
+			continue
+		}
+		if len(record) <= opts.TimeColumn || len(record) <= opts.ValueColumn {
+			return added, fmt.Errorf("line %d: missing columns", line)
+		}
+		when, err := parseTime(strings.TrimSpace(record[opts.TimeColumn]), opts.TimeFormat, opts.Location)
+		if err != nil {
+			return added, fmt.Errorf("line %d: %s", line, err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[opts.ValueColumn]), 64)
+		if err != nil {
+			return added, fmt.Errorf("line %d: %s", line, err)
+		}
+		err = t.AddWithTime(value, when)
+		if errors.Is(err, ErrPointDropped) {
+			continue
+		}
+		if err != nil {
+			return added, fmt.Errorf("line %d: %w", line, err)
+		}
+		added = added + 1
+	}
+}
+
+func formatTime(when time.Time, format string) string {
+	switch format {
+	case "":
+		return when.Format(time.RFC3339Nano)
+	case TimeFormatUnix:
+		return strconv.FormatFloat(float64(when.UnixNano())/1e9, 'f', -1, 64)
+	case TimeFormatUnixMilli:
+		return strconv.FormatInt(when.UnixNano()/int64(time.Millisecond), 10)
+	case TimeFormatUnixNano:
+		return strconv.FormatInt(when.UnixNano(), 10)
+	}
+	return when.Format(format)
+}
+
+func parseTime(value string, format string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch format {
+	case "":
+		return time.ParseInLocation(time.RFC3339Nano, value, loc)
+	case TimeFormatUnix:
+		s, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		sec, frac := math.Modf(s)
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).In(loc), nil
+	case TimeFormatUnixMilli, TimeFormatUnixNano:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if format == TimeFormatUnixMilli {
+			n = n * int64(time.Millisecond)
+		}
+		return time.Unix(0, n).In(loc), nil
+	}
+	return time.ParseInLocation(format, value, loc)
+}
+
+//lineProtocolEscaper escapes tag keys/values and field keys in InfluxDB line protocol
+var lineProtocolEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `)
+
+//measurementEscaper escapes measurement names in InfluxDB line protocol, in which '=' is not escaped
+var measurementEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, " ", `\ `)
+
+//WriteLineProtocol writes all points of this timeseries to 'w' in InfluxDB line protocol, one line per point,
+//with nanosecond timestamps. 'field' is the name of the value field ("value" if empty)
+//NaN and infinite values are skipped, as they are not supported by InfluxDB
+func (t *Timeseries) WriteLineProtocol(w io.Writer, measurement string, tags map[string]string, field string) error {
+	if measurement == "" {
+		return fmt.Errorf("measurement must not be empty")
+	}
+	if field == "" {
+		field = "value"
+	}
+	prefix := measurementEscaper.Replace(measurement)
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	//sorted tags are faster to ingest
+	sort.Strings(keys)
+	for _, k := range keys {
+		//empty tag values are not allowed
+		if tags[k] == "" {
+			continue
+		}
+		prefix = prefix + "," + lineProtocolEscaper.Replace(k) + "=" + lineProtocolEscaper.Replace(tags[k])
+	}
+	prefix = prefix + " " + lineProtocolEscaper.Replace(field) + "="
+
+	bw := bufio.NewWriter(w)
+	it := t.Iterator()
+	for it.Next() {
+		tv := it.At()
+		if math.IsNaN(tv.Value) || math.IsInf(tv.Value, 0) {
+			continue
+		}
+		_, err := fmt.Fprintf(bw, "%s%s %d\n", prefix, strconv.FormatFloat(tv.Value, 'g', -1, 64), tv.Time.UnixNano())
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
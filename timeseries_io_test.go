@@ -0,0 +1,147 @@
+package signalutils
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTSWriteReadCSV(t *testing.T) {
+	ts, start := newResampleTestTS()
+	ts.AddWithTime(math.NaN(), start.Add(5500*time.Millisecond))
+
+	buf := bytes.Buffer{}
+	assert.Nil(t, ts.WriteCSV(&buf, DefaultCSVOptions()))
+	assert.Equal(t, "time,value\n"+
+		"2020-01-01T00:00:00Z,0\n"+
+		"2020-01-01T00:00:01Z,10\n"+
+		"2020-01-01T00:00:03Z,30\n"+
+		"2020-01-01T00:00:04Z,20\n"+
+		"2020-01-01T00:00:05.5Z,NaN\n", buf.String())
+
+	r := NewTimeseriesWithClock(1*time.Hour, NewManualClock(start))
+	n, err := r.ReadCSV(&buf, DefaultCSVOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []float64{0, 10, 30, 20}, tsValues(r)[:4])
+	assert.True(t, math.IsNaN(r.Values[4].Value))
+	assert.True(t, start.Add(5500*time.Millisecond).Equal(r.Values[4].Time))
+}
+
+func TestTSCSVOptions(t *testing.T) {
+	ts, start := newResampleTestTS()
+	opts := CSVOptions{TimeColumn: 2, ValueColumn: 0, TimeFormat: TimeFormatUnixMilli, Comma: ';'}
+	buf := bytes.Buffer{}
+	assert.Nil(t, ts.WriteCSV(&buf, opts))
+	assert.Equal(t, "0;;1577836800000\n10;;1577836801000\n30;;1577836803000\n20;;1577836804000\n", buf.String())
+
+	for _, format := range []string{TimeFormatUnix, TimeFormatUnixNano, "2006-01-02 15:04:05.000"} {
+		opts = CSVOptions{TimeColumn: 1, ValueColumn: 3, TimeFormat: format, Header: true}
+		buf = bytes.Buffer{}
+		assert.Nil(t, ts.WriteCSV(&buf, opts))
+		r := NewTimeseriesWithClock(1*time.Hour, NewManualClock(start))
+		n, err := r.ReadCSV(&buf, opts)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, tsValues(ts), tsValues(r))
+		for i, v := range r.Values {
+			assert.True(t, ts.Values[i].Time.Equal(v.Time))
+		}
+	}
+
+	//layout without time zone in other location
+	loc := time.FixedZone("UTC-3", -3*60*60)
+	r := NewTimeseriesWithClock(1*time.Hour, NewManualClock(start))
+	n, err := r.ReadCSV(strings.NewReader("x, 2019-12-31 21:00:01 ,1.5\n"), CSVOptions{TimeColumn: 1, ValueColumn: 2, TimeFormat: "2006-01-02 15:04:05", Location: loc})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.True(t, start.Add(1*time.Second).Equal(r.Values[0].Time))
+
+	//dropped points don't stop reading
+	n, err = r.ReadCSV(strings.NewReader("2020-01-01T00:00:00Z,1\n2020-01-01T00:00:02Z,2\n"), CSVOptions{ValueColumn: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(1), r.Dropped())
+}
+
+func TestTSReadCSVErrors(t *testing.T) {
+	ts := NewTimeseries(1 * time.Hour)
+	_, err := ts.ReadCSV(strings.NewReader("2020-01-01T00:00:00Z,1\n"), CSVOptions{})
+	assert.NotNil(t, err)
+	n, err := ts.ReadCSV(strings.NewReader("2020-01-01T00:00:00Z,1\n2020-01-01T00:00:01Z,x\n"), CSVOptions{ValueColumn: 1})
+	assert.Equal(t, 1, n)
+	assert.Contains(t, err.Error(), "line 2")
+	_, err = ts.ReadCSV(strings.NewReader("2020-01-01T00:00:05Z\n"), CSVOptions{ValueColumn: 1})
+	assert.Contains(t, err.Error(), "line 1")
+	_, err = ts.ReadCSV(strings.NewReader("yesterday,1\n"), CSVOptions{ValueColumn: 1})
+	assert.Contains(t, err.Error(), "line 1")
+
+	//points that can't be written to the WAL stop the import
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	wal, err := OpenWAL(dir, 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, ts.AttachWAL(wal))
+	wal.Close()
+	n, err = ts.ReadCSV(strings.NewReader("2020-01-01T00:00:00Z,1\n2020-01-01T01:00:00Z,2\n"), CSVOptions{ValueColumn: 1})
+	assert.Equal(t, 0, n)
+	assert.Contains(t, err.Error(), "line 2")
+	assert.False(t, errors.Is(err, ErrPointDropped))
+}
+
+func TestTSWriteLineProtocol(t *testing.T) {
+	ts, start := newResampleTestTS()
+	ts.AddWithTime(math.Inf(1), start.Add(5*time.Second))
+	ts.AddWithTime(1500000, start.Add(6*time.Second))
+	buf := bytes.Buffer{}
+	err := ts.WriteLineProtocol(&buf, "cpu load", map[string]string{"host": "a,b", "dc": "x=1", "empty": ""}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, `cpu\ load,dc=x\=1,host=a\,b value=0 1577836800000000000
+cpu\ load,dc=x\=1,host=a\,b value=10 1577836801000000000
+cpu\ load,dc=x\=1,host=a\,b value=30 1577836803000000000
+cpu\ load,dc=x\=1,host=a\,b value=20 1577836804000000000
+cpu\ load,dc=x\=1,host=a\,b value=1.5e+06 1577836806000000000
+`, buf.String())
+
+	assert.NotNil(t, ts.WriteLineProtocol(&buf, "", nil, "v"))
+
+	//'=' is escaped in tags only and backslashes everywhere
+	ts = NewTimeseries(1 * time.Hour)
+	ts.AddWithTime(1, start)
+	buf.Reset()
+	err = ts.WriteLineProtocol(&buf, `a=b,c\`, map[string]string{`k=\`: `v\`}, `f\`)
+	assert.Nil(t, err)
+	assert.Equal(t, `a=b\,c\\,k\=\\=v\\ f\\=1 1577836800000000000
+`, buf.String())
+}
+
+func TestTSCSVReplay(t *testing.T) {
+	//recorded signal replayed into a schmitt trigger
+	recording := "time,value\n" +
+		"2020-01-01T00:00:00Z,1\n" +
+		"2020-01-01T00:00:01Z,5\n" +
+		"2020-01-01T00:00:02Z,11\n" +
+		"2020-01-01T00:00:03Z,8\n" +
+		"2020-01-01T00:00:04Z,2\n"
+	c := NewManualClock(time.Now())
+	ts := NewTimeseriesWithClock(1*time.Minute, c)
+	ts.SetRetentionPolicy(RetentionPolicy{Reference: RetentionLastSample})
+	n, err := ts.ReadCSV(strings.NewReader(recording), DefaultCSVOptions())
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+
+	st, _ := NewSchmittTrigger(3, 10, false)
+	states := make([]bool, 0)
+	it := ts.Iterator()
+	for it.Next() {
+		st.SetCurrentValue(it.At().Value)
+		states = append(states, st.IsUpperRange())
+	}
+	assert.Equal(t, []bool{false, false, true, true, false}, states)
+}
@@ -0,0 +1,101 @@
+package signalutils
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+//TimeseriesIterator iterates over the points of a Timeseries as they were when the iterator was created,
+//without copying them. Points added or changed afterwards are not seen by the iterator.
+//It is safe to use while the timeseries is being changed by other goroutines, but
+//an iterator itself must not be used by multiple goroutines
+//Only initialize this with Timeseries.Iterator()
+type TimeseriesIterator struct {
+	values  []TimeValue
+	chunks  []*gorillaChunk
+	offsets []int
+	count   int
+	loc     *time.Location
+	i       int
+	ci      int
+	chunkIt *chunkIterator
+	cur     TimeValue
+}
+
+//Iterator creates an iterator over a snapshot of the current points of this timeseries
+//The iterator starts before the first point, so Next() or Seek(..) must be called before At()
+func (t *Timeseries) Iterator() *TimeseriesIterator {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	it := &TimeseriesIterator{i: -1}
+	if t.chunks != nil {
+		atomic.StoreInt32(&t.chunks.shared, 1)
+		it.chunks = t.chunks.chunks
+		it.offsets = t.chunks.offsets
+		it.count = t.chunks.count
+		it.loc = t.chunks.loc
+		return it
+	}
+	atomic.StoreInt32(&t.shared, 1)
+	it.values = t.Values
+	it.count = len(t.Values)
+	return it
+}
+
+//Next moves to the next point. Returns false if there are no more points
+func (it *TimeseriesIterator) Next() bool {
+	if it.i+1 >= it.count {
+		it.i = it.count
+		return false
+	}
+	it.i = it.i + 1
+	if it.chunks == nil {
+		it.cur = it.values[it.i]
+		return true
+	}
+	if it.chunkIt == nil || !it.chunkIt.next() {
+		it.ci = sort.Search(len(it.offsets), func(k int) bool {
+			return it.offsets[k] > it.i
+		}) - 1
+		it.chunkIt = it.chunks[it.ci].iterator(it.loc)
+		for j := it.offsets[it.ci]; j <= it.i; j++ {
+			it.chunkIt.next()
+		}
+	}
+	it.cur = it.chunkIt.at()
+	return true
+}
+
+//Seek moves to the first point with time equal or after 'when', either forward or backwards.
+//Returns false if there is no such point
+func (it *TimeseriesIterator) Seek(when time.Time) bool {
+	var i int
+	if it.chunks == nil {
+		i = sort.Search(it.count, func(k int) bool {
+			return !it.values[k].Time.Before(when)
+		})
+	} else {
+		ci := sort.Search(len(it.chunks), func(k int) bool {
+			return !it.chunks[k].last.Time.Before(when)
+		})
+		i = it.count
+		if ci < len(it.chunks) {
+			i = it.offsets[ci]
+		}
+	}
+	//restart from the point before the candidate and move forward
+	it.i = i - 1
+	it.chunkIt = nil
+	for it.Next() {
+		if !it.cur.Time.Before(when) {
+			return true
+		}
+	}
+	return false
+}
+
+//At current point of the iterator
+func (it *TimeseriesIterator) At() TimeValue {
+	return it.cur
+}
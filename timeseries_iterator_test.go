@@ -0,0 +1,137 @@
+package signalutils
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func iteratorValues(it *TimeseriesIterator) []float64 {
+	vs := make([]float64, 0)
+	for it.Next() {
+		vs = append(vs, it.At().Value)
+	}
+	return vs
+}
+
+func TestTSIterator(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		start := c.Now()
+		ts := NewTimeseriesWithClock(1*time.Hour, c)
+		if compressed {
+			ts = NewCompressedTimeseriesWithClock(1*time.Hour, c)
+		}
+		expected := make([]float64, 0)
+		for i := 0; i < 300; i++ {
+			ts.AddWithTime(float64(i), start.Add(time.Duration(2*i)*time.Second))
+			expected = append(expected, float64(i))
+		}
+
+		it := ts.Iterator()
+		assert.Equal(t, expected, iteratorValues(it))
+		assert.False(t, it.Next())
+
+		assert.True(t, it.Seek(start.Add(241*time.Second)))
+		assert.Equal(t, 121.0, it.At().Value)
+		assert.Equal(t, start.Add(242*time.Second), it.At().Time)
+		assert.True(t, it.Next())
+		assert.Equal(t, 122.0, it.At().Value)
+
+		//backwards
+		assert.True(t, it.Seek(start.Add(-1*time.Second)))
+		assert.Equal(t, 0.0, it.At().Value)
+		assert.True(t, it.Seek(start.Add(598*time.Second)))
+		assert.Equal(t, 299.0, it.At().Value)
+		assert.False(t, it.Next())
+		assert.False(t, it.Seek(start.Add(599*time.Second)))
+
+		empty := NewTimeseries(1 * time.Second)
+		it = empty.Iterator()
+		assert.False(t, it.Next())
+		assert.False(t, it.Seek(start))
+	}
+}
+
+func TestTSIteratorSnapshot(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		start := c.Now()
+		ts := NewTimeseriesWithClock(1*time.Hour, c)
+		if compressed {
+			ts = NewCompressedTimeseriesWithClock(1*time.Hour, c)
+		}
+		ts.SetInsertPolicy(InsertPolicy{OutOfOrder: OutOfOrderInsert, Tolerance: 1 * time.Hour, Duplicate: DuplicateOverwrite})
+		ts.AddWithTime(0, start)
+		ts.AddWithTime(2, start.Add(2*time.Second))
+		ts.AddWithTime(4, start.Add(4*time.Second))
+
+		it := ts.Iterator()
+		//changes in place are not seen by the iterator
+		ts.AddWithTime(1, start.Add(1*time.Second))
+		ts.AddWithTime(40, start.Add(4*time.Second))
+		ts.AddWithTime(5, start.Add(5*time.Second))
+		assert.Equal(t, []float64{0, 2, 4}, iteratorValues(it))
+		assert.Equal(t, []float64{0, 1, 2, 40, 5}, iteratorValues(ts.Iterator()))
+	}
+}
+
+func TestTSIteratorConcurrent(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		start := c.Now()
+		ts := NewTimeseriesWithClock(1*time.Hour, c)
+		if compressed {
+			ts = NewCompressedTimeseriesWithClock(1*time.Hour, c)
+		}
+		ts.SetInsertPolicy(InsertPolicy{Duplicate: DuplicateSum})
+		ts.SetRetentionPolicy(RetentionPolicy{MaxPoints: 500})
+		for i := 0; i < 200; i++ {
+			ts.AddWithTime(1, start.Add(time.Duration(i)*time.Second))
+		}
+
+		wg := sync.WaitGroup{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 200; i < 1000; i++ {
+				when := start.Add(time.Duration(i) * time.Second)
+				ts.AddWithTime(1, when)
+				ts.AddWithTime(1, when)
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			it := ts.Iterator()
+			n := 0
+			prev := time.Time{}
+			for it.Next() {
+				assert.True(t, it.At().Time.After(prev))
+				prev = it.At().Time
+				n = n + 1
+			}
+			assert.True(t, n >= 200 && n <= 500)
+		}
+		wg.Wait()
+	}
+}
+
+func TestTSIteratorReadLock(t *testing.T) {
+	for _, ts := range []Timeseries{NewTimeseries(1 * time.Hour), NewCompressedTimeseries(1 * time.Hour)} {
+		ts.Add(1)
+		//iterators don't block other readers
+		ts.m.RLock()
+		done := make(chan *TimeseriesIterator)
+		go func() {
+			done <- ts.Iterator()
+		}()
+		select {
+		case it := <-done:
+			assert.Equal(t, []float64{1}, iteratorValues(it))
+		case <-time.After(1 * time.Second):
+			assert.Fail(t, "Iterator() blocked by a reader")
+		}
+		ts.m.RUnlock()
+	}
+}
@@ -0,0 +1,107 @@
+package signalutils
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+//OverTimeFunc aggregates the values of the points inside a time window into a single value
+//'values' is never empty
+type OverTimeFunc func(values []float64) float64
+
+//AvgOverTime average of the values in window
+func AvgOverTime(values []float64) float64 {
+	return SumOverTime(values) / float64(len(values))
+}
+
+//SumOverTime sum of the values in window
+func SumOverTime(values []float64) float64 {
+	sum := kahanSum{}
+	for _, v := range values {
+		sum.add(v)
+	}
+	return sum.value()
+}
+
+//MaxOverTime max value in window. Like in Prometheus, NaN values are skipped, unless all values are NaN
+func MaxOverTime(values []float64) float64 {
+	r := values[0]
+	for _, v := range values[1:] {
+		if v > r || math.IsNaN(r) {
+			r = v
+		}
+	}
+	return r
+}
+
+//MinOverTime min value in window. Like in Prometheus, NaN values are skipped, unless all values are NaN
+func MinOverTime(values []float64) float64 {
+	r := values[0]
+	for _, v := range values[1:] {
+		if v < r || math.IsNaN(r) {
+			r = v
+		}
+	}
+	return r
+}
+
+//CountOverTime number of points in window
+func CountOverTime(values []float64) float64 {
+	return float64(len(values))
+}
+
+//QuantileOverTime returns a function that calculates the 'q' quantile (0-1) of the values in window
+//Values are linearly interpolated between ranks, just like Prometheus' quantile_over_time.
+//q < 0 results in -Inf and q > 1 in +Inf
+func QuantileOverTime(q float64) OverTimeFunc {
+	return func(values []float64) float64 {
+		if q < 0 {
+			return math.Inf(-1)
+		}
+		if q > 1 {
+			return math.Inf(1)
+		}
+		sorted := make([]float64, len(values))
+		copy(sorted, values)
+		sort.Float64s(sorted)
+		rank := q * float64(len(sorted)-1)
+		lower := math.Floor(rank)
+		upper := math.Ceil(rank)
+		w := rank - lower
+		return sorted[int(lower)]*(1-w) + sorted[int(upper)]*w
+	}
+}
+
+//OverTime calculates a new Timeseries with one point at each 'step' from 'from' to 'to' (inclusive), in which each
+//point at time T is the aggregation by 'fn' of the points of this timeseries with time in (T-window, T]
+//Use window == step for tumbling windows (each point is used once) and window > step for sliding windows
+//Steps without points in window are skipped. Just like Prometheus' *_over_time functions
+func (t *Timeseries) OverTime(from time.Time, to time.Time, step time.Duration, window time.Duration, fn OverTimeFunc) (Timeseries, error) {
+	if step <= 0 || window <= 0 {
+		return Timeseries{}, fmt.Errorf("step and window must be positive")
+	}
+	if to.Before(from) {
+		return Timeseries{}, fmt.Errorf("'to' must not be before 'from'")
+	}
+	t.m.RLock()
+	defer t.m.RUnlock()
+	vs := make([]TimeValue, 0)
+	values := make([]float64, 0)
+	for when := from; !when.After(to); when = when.Add(step) {
+		//window is left open
+		i1 := t.searchTo(when.Add(-window))
+		i2 := t.searchTo(when)
+		if i2 <= i1 {
+			continue
+		}
+		values = values[:0]
+		for _, v := range t.points(i1, i2) {
+			values = append(values, v.Value)
+		}
+		vs = append(vs, TimeValue{when, fn(values)})
+	}
+
+	return newDerivedTimeseries(t.TimeseriesSpan, t.clock, t.chunks != nil, vs), nil
+}
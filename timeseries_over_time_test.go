@@ -0,0 +1,92 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverTimeFuncs(t *testing.T) {
+	values := []float64{3, 1, 4, 1, 5}
+	assert.Equal(t, 2.8, AvgOverTime(values))
+	assert.Equal(t, 14.0, SumOverTime(values))
+	assert.Equal(t, 5.0, MaxOverTime(values))
+	assert.Equal(t, 1.0, MinOverTime(values))
+	assert.Equal(t, 5.0, CountOverTime(values))
+	assert.Equal(t, 3.0, QuantileOverTime(0.5)(values))
+	assert.Equal(t, 1.0, QuantileOverTime(0)(values))
+	assert.Equal(t, 5.0, QuantileOverTime(1)(values))
+	//sorted 1,1,3,4,5 - rank 3.6
+	assert.InDelta(t, 4.6, QuantileOverTime(0.9)(values), 0.0001)
+	assert.True(t, math.IsInf(QuantileOverTime(-1)(values), -1))
+	assert.True(t, math.IsInf(QuantileOverTime(2)(values), 1))
+	//input is not changed
+	assert.Equal(t, []float64{3, 1, 4, 1, 5}, values)
+
+	//NaN values are skipped by max and min, unless all values are NaN
+	values = []float64{math.NaN(), 3, math.NaN(), 1, 4}
+	assert.Equal(t, 4.0, MaxOverTime(values))
+	assert.Equal(t, 1.0, MinOverTime(values))
+	assert.Equal(t, 3.0, MaxOverTime([]float64{3, math.NaN()}))
+	assert.Equal(t, 3.0, MinOverTime([]float64{3, math.NaN()}))
+	assert.True(t, math.IsNaN(MaxOverTime([]float64{math.NaN(), math.NaN()})))
+	assert.True(t, math.IsNaN(MinOverTime([]float64{math.NaN()})))
+}
+
+func TestOverTimeTumbling(t *testing.T) {
+	ts, start := newResampleTestTS()
+	//points: 0@0s 10@1s 30@3s 20@4s
+
+	r, err := ts.OverTime(start, start.Add(4*time.Second), 2*time.Second, 2*time.Second, SumOverTime)
+	assert.Nil(t, err)
+	//(-2s,0s] (0s,2s] (2s,4s]
+	assert.Equal(t, []float64{0, 10, 50}, tsValues(r))
+	assert.Equal(t, start.Add(2*time.Second), r.Values[1].Time)
+
+	r, _ = ts.OverTime(start, start.Add(4*time.Second), 2*time.Second, 2*time.Second, CountOverTime)
+	assert.Equal(t, []float64{1, 1, 2}, tsValues(r))
+
+	//empty windows are skipped
+	r, _ = ts.OverTime(start, start.Add(10*time.Second), 1*time.Second, 1*time.Second, MaxOverTime)
+	assert.Equal(t, []float64{0, 10, 30, 20}, tsValues(r))
+	assert.Equal(t, start.Add(3*time.Second), r.Values[2].Time)
+}
+
+func TestOverTimeSliding(t *testing.T) {
+	ts, start := newResampleTestTS()
+
+	r, err := ts.OverTime(start, start.Add(5*time.Second), 1*time.Second, 3*time.Second, AvgOverTime)
+	assert.Nil(t, err)
+	//(-3,0] (-2,1] (-1,2] (0,3] (1,4] (2,5]
+	assert.Equal(t, []float64{0, 5, 5, 20, 25, 25}, tsValues(r))
+
+	r, _ = ts.OverTime(start, start.Add(5*time.Second), 1*time.Second, 3*time.Second, MinOverTime)
+	assert.Equal(t, []float64{0, 0, 0, 10, 20, 20}, tsValues(r))
+
+	r, _ = ts.OverTime(start, start.Add(4*time.Second), 4*time.Second, 10*time.Second, QuantileOverTime(0.5))
+	assert.Equal(t, []float64{0, 15}, tsValues(r))
+}
+
+func TestOverTimeChained(t *testing.T) {
+	ts, start := newResampleTestTS()
+	r, _ := ts.OverTime(start, start.Add(4*time.Second), 1*time.Second, 2*time.Second, SumOverTime)
+	assert.Equal(t, []float64{0, 10, 10, 30, 50}, tsValues(r))
+	r2, err := r.OverTime(start, start.Add(4*time.Second), 2*time.Second, 2*time.Second, MaxOverTime)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0, 10, 50}, tsValues(r2))
+	v, ok := r2.Last()
+	assert.True(t, ok)
+	assert.Equal(t, 50.0, v.Value)
+}
+
+func TestOverTimeInvalid(t *testing.T) {
+	ts, start := newResampleTestTS()
+	_, err := ts.OverTime(start, start.Add(4*time.Second), 0, 1*time.Second, AvgOverTime)
+	assert.NotNil(t, err)
+	_, err = ts.OverTime(start, start.Add(4*time.Second), 1*time.Second, 0, AvgOverTime)
+	assert.NotNil(t, err)
+	_, err = ts.OverTime(start.Add(4*time.Second), start, 1*time.Second, 1*time.Second, AvgOverTime)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,200 @@
+package signalutils
+
+import (
+	"fmt"
+	"time"
+)
+
+//ResampleMethod how values are calculated for each point when resampling a Timeseries
+type ResampleMethod int
+
+const (
+	//ResampleLinear linear interpolation between the two neighboring points
+	ResampleLinear ResampleMethod = iota
+	//ResamplePrevious value of the last point at or before the time (step function)
+	ResamplePrevious
+	//ResampleNext value of the first point at or after the time
+	ResampleNext
+	//ResampleNearest value of the point closest in time
+	ResampleNearest
+	//ResampleCubicSpline natural cubic spline interpolation
+	ResampleCubicSpline
+	//ResampleMean average of the points inside each step (downsampling)
+	ResampleMean
+	//ResampleMax max value of the points inside each step (downsampling)
+	ResampleMax
+	//ResampleMin min value of the points inside each step (downsampling)
+	ResampleMin
+	//ResampleSum sum of the points inside each step (downsampling)
+	ResampleSum
+	//ResampleLast value of the last point inside each step (downsampling)
+	ResampleLast
+)
+
+//Resample creates a new timeseries with points evenly spaced by 'step', starting at 'from' until 'to' (inclusive)
+//For interpolation methods (ResampleLinear, ResamplePrevious, ResampleNext, ResampleNearest and ResampleCubicSpline)
+//each new point is calculated from the neighboring points. No values are extrapolated, so there will be
+//points only for times between the first and last points of this timeseries.
+//For aggregation methods (ResampleMean, ResampleMax, ResampleMin, ResampleSum and ResampleLast) each new point
+//at time T aggregates the points with time in [T, T+step). Steps without points are skipped
+func (t *Timeseries) Resample(from time.Time, to time.Time, step time.Duration, method ResampleMethod) (Timeseries, error) {
+	if step <= 0 {
+		return Timeseries{}, fmt.Errorf("step must be positive")
+	}
+	if to.Before(from) {
+		return Timeseries{}, fmt.Errorf("'to' must not be before 'from'")
+	}
+	t.m.RLock()
+	defer t.m.RUnlock()
+
+	var vs []TimeValue
+	switch method {
+	case ResampleLinear, ResamplePrevious, ResampleNext, ResampleNearest:
+		vs = t.resampleInterpolate(from, to, step, method)
+	case ResampleCubicSpline:
+		vs = t.resampleCubicSpline(from, to, step)
+	case ResampleMean, ResampleMax, ResampleMin, ResampleSum, ResampleLast:
+		vs = t.resampleAggregate(from, to, step, method)
+	default:
+		return Timeseries{}, fmt.Errorf("unknown resample method %d", method)
+	}
+
+	return newDerivedTimeseries(t.TimeseriesSpan, t.clock, t.chunks != nil, vs), nil
+}
+
+func (t *Timeseries) resampleInterpolate(from time.Time, to time.Time, step time.Duration, method ResampleMethod) []TimeValue {
+	vs := make([]TimeValue, 0)
+	for when := from; !when.After(to); when = when.Add(step) {
+		i1, i2, ok := t.pos(when)
+		if !ok {
+			continue
+		}
+		v1 := t.at(i1)
+		v2 := t.at(i2)
+		value := v1.Value
+		switch method {
+		case ResampleLinear:
+			tv, _ := t.get(when)
+			value = tv.Value
+		case ResamplePrevious:
+			value = v1.Value
+		case ResampleNext:
+			value = v2.Value
+		case ResampleNearest:
+			if v2.Time.Sub(when) < when.Sub(v1.Time) {
+				value = v2.Value
+			}
+		}
+		vs = append(vs, TimeValue{when, value})
+	}
+	return vs
+}
+
+func (t *Timeseries) resampleCubicSpline(from time.Time, to time.Time, step time.Duration) []TimeValue {
+	//build spline with the points in range plus one neighbor on each side
+	i1, i2 := t.rangeIndexes(from, to)
+	if i1 > 0 {
+		i1 = i1 - 1
+	}
+	if i2 < t.size() {
+		i2 = i2 + 1
+	}
+	points := t.points(i1, i2)
+	if len(points) < 3 {
+		return t.resampleInterpolate(from, to, step, ResampleLinear)
+	}
+
+	//x in seconds relative to first point for numerical stability
+	base := points[0].Time
+	n := len(points)
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	for i, p := range points {
+		xs[i] = p.Time.Sub(base).Seconds()
+		ys[i] = p.Value
+	}
+	m := naturalSplineSecondDerivatives(xs, ys)
+
+	vs := make([]TimeValue, 0)
+	j := 0
+	for when := from; !when.After(to); when = when.Add(step) {
+		if when.Before(points[0].Time) || when.After(points[n-1].Time) {
+			continue
+		}
+		x := when.Sub(base).Seconds()
+		for j < n-2 && xs[j+1] < x {
+			j = j + 1
+		}
+		h := xs[j+1] - xs[j]
+		a := xs[j+1] - x
+		b := x - xs[j]
+		y := m[j]*a*a*a/(6*h) + m[j+1]*b*b*b/(6*h) +
+			(ys[j]/h-m[j]*h/6)*a + (ys[j+1]/h-m[j+1]*h/6)*b
+		vs = append(vs, TimeValue{when, y})
+	}
+	return vs
+}
+
+//naturalSplineSecondDerivatives solves the tridiagonal system of a natural cubic spline (Thomas algorithm)
+func naturalSplineSecondDerivatives(xs []float64, ys []float64) []float64 {
+	n := len(xs)
+	m := make([]float64, n)
+	c := make([]float64, n)
+	d := make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		h0 := xs[i] - xs[i-1]
+		h1 := xs[i+1] - xs[i]
+		r := 6 * ((ys[i+1]-ys[i])/h1 - (ys[i]-ys[i-1])/h0)
+		diag := 2 * (h0 + h1)
+		if i > 1 {
+			diag = diag - h0*c[i-1]
+			r = r - h0*d[i-1]
+		}
+		c[i] = h1 / diag
+		d[i] = r / diag
+	}
+	for i := n - 2; i > 0; i-- {
+		m[i] = d[i] - c[i]*m[i+1]
+	}
+	return m
+}
+
+func (t *Timeseries) resampleAggregate(from time.Time, to time.Time, step time.Duration, method ResampleMethod) []TimeValue {
+	vs := make([]TimeValue, 0)
+	i1, _ := t.rangeIndexes(from, to)
+	bucket := int64(-1)
+	values := make([]float64, 0)
+	flush := func() {
+		if len(values) > 0 {
+			vs = append(vs, TimeValue{from.Add(time.Duration(bucket) * step), aggregateValues(values, method)})
+		}
+		values = values[:0]
+	}
+	for _, p := range t.points(i1, t.size()) {
+		b := int64(p.Time.Sub(from) / step)
+		if from.Add(time.Duration(b) * step).After(to) {
+			break
+		}
+		if b != bucket {
+			flush()
+			bucket = b
+		}
+		values = append(values, p.Value)
+	}
+	flush()
+	return vs
+}
+
+func aggregateValues(values []float64, method ResampleMethod) float64 {
+	switch method {
+	case ResampleMean:
+		return AvgOverTime(values)
+	case ResampleSum:
+		return SumOverTime(values)
+	case ResampleMax:
+		return MaxOverTime(values)
+	case ResampleMin:
+		return MinOverTime(values)
+	}
+	return values[len(values)-1]
+}
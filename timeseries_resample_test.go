@@ -0,0 +1,134 @@
+package signalutils
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newResampleTestTS() (Timeseries, time.Time) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	ts.AddWithTime(0, start)
+	ts.AddWithTime(10, start.Add(1*time.Second))
+	ts.AddWithTime(30, start.Add(3*time.Second))
+	ts.AddWithTime(20, start.Add(4*time.Second))
+	return ts, start
+}
+
+func tsValues(ts Timeseries) []float64 {
+	vs := make([]float64, 0)
+	for _, v := range ts.Values {
+		vs = append(vs, v.Value)
+	}
+	return vs
+}
+
+func TestResampleInterpolation(t *testing.T) {
+	ts, start := newResampleTestTS()
+	from := start.Add(-1 * time.Second)
+	to := start.Add(5 * time.Second)
+
+	r, err := ts.Resample(from, to, 500*time.Millisecond, ResampleLinear)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0, 5, 10, 15, 20, 25, 30, 25, 20}, tsValues(r))
+	assert.Equal(t, start, r.Values[0].Time)
+	assert.Equal(t, start.Add(4*time.Second), r.Values[8].Time)
+
+	r, _ = ts.Resample(from, to, 1*time.Second, ResamplePrevious)
+	assert.Equal(t, []float64{0, 10, 10, 30, 20}, tsValues(r))
+
+	r, _ = ts.Resample(from, to, 1*time.Second, ResampleNext)
+	assert.Equal(t, []float64{0, 10, 30, 30, 20}, tsValues(r))
+
+	r, _ = ts.Resample(start.Add(1200*time.Millisecond), to, 1*time.Second, ResampleNearest)
+	assert.Equal(t, []float64{10, 30, 30}, tsValues(r))
+}
+
+func TestResampleCubicSpline(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	for i := 0; i <= 40; i++ {
+		ts.AddWithTime(math.Sin(float64(i)/4), start.Add(time.Duration(i)*time.Second))
+	}
+	r, err := ts.Resample(start.Add(5*time.Second), start.Add(35*time.Second), 100*time.Millisecond, ResampleCubicSpline)
+	assert.Nil(t, err)
+	assert.Equal(t, 301, r.Size())
+	for _, v := range r.Values {
+		x := v.Time.Sub(start).Seconds()
+		assert.InDelta(t, math.Sin(x/4), v.Value, 0.002)
+	}
+
+	//spline passes through original points
+	ts2, start2 := newResampleTestTS()
+	r, _ = ts2.Resample(start2, start2.Add(4*time.Second), 1*time.Second, ResampleCubicSpline)
+	assert.Equal(t, 0.0, r.Values[0].Value)
+	assert.InDelta(t, 10.0, r.Values[1].Value, 1e-9)
+	assert.InDelta(t, 30.0, r.Values[3].Value, 1e-9)
+	assert.InDelta(t, 20.0, r.Values[4].Value, 1e-9)
+}
+
+func TestResampleAggregation(t *testing.T) {
+	ts, start := newResampleTestTS()
+	from := start
+	to := start.Add(4 * time.Second)
+
+	r, err := ts.Resample(from, to, 2*time.Second, ResampleMean)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{5, 30, 20}, tsValues(r))
+	assert.Equal(t, start.Add(2*time.Second), r.Values[1].Time)
+
+	r, _ = ts.Resample(from, to, 2*time.Second, ResampleMax)
+	assert.Equal(t, []float64{10, 30, 20}, tsValues(r))
+	r, _ = ts.Resample(from, to, 2*time.Second, ResampleMin)
+	assert.Equal(t, []float64{0, 30, 20}, tsValues(r))
+	r, _ = ts.Resample(from, to, 2*time.Second, ResampleSum)
+	assert.Equal(t, []float64{10, 30, 20}, tsValues(r))
+	r, _ = ts.Resample(from, to, 2*time.Second, ResampleLast)
+	assert.Equal(t, []float64{10, 30, 20}, tsValues(r))
+
+	//empty buckets are skipped
+	r, _ = ts.Resample(from, to, 1*time.Second, ResampleSum)
+	assert.Equal(t, []float64{0, 10, 30, 20}, tsValues(r))
+	assert.Equal(t, start.Add(3*time.Second), r.Values[2].Time)
+
+	//points after 'to' bucket are ignored
+	r, _ = ts.Resample(from, start.Add(3500*time.Millisecond), 3*time.Second, ResampleSum)
+	assert.Equal(t, []float64{10, 50}, tsValues(r))
+}
+
+func TestResampleErrors(t *testing.T) {
+	ts, start := newResampleTestTS()
+	_, err := ts.Resample(start, start.Add(1*time.Second), 0, ResampleLinear)
+	assert.NotNil(t, err)
+	_, err = ts.Resample(start.Add(1*time.Second), start, time.Second, ResampleLinear)
+	assert.NotNil(t, err)
+	_, err = ts.Resample(start, start.Add(1*time.Second), time.Second, ResampleMethod(99))
+	assert.NotNil(t, err)
+}
+
+func TestResampleDerivedTimeseries(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewCompressedTimeseriesWithClock(1*time.Hour, c)
+	for i := 0; i <= 10; i++ {
+		ts.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	//results of compressed timeseries are compressed too
+	r, err := ts.Resample(start, start.Add(10*time.Second), 2*time.Second, ResampleLinear)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, r.Size())
+	assert.Equal(t, 0, len(r.Values))
+	_, vs := r.ValuesRange(start, start.Add(10*time.Second))
+	assert.Equal(t, []float64{0, 2, 4, 6, 8, 10}, vs)
+
+	//historical points are not pruned on additions
+	c.Advance(2 * time.Hour)
+	r.Add(100)
+	assert.Equal(t, 7, r.Size())
+}
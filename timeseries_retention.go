@@ -0,0 +1,105 @@
+package signalutils
+
+import (
+	"context"
+	"time"
+)
+
+//RetentionReference the time from which TimeseriesSpan is counted back when pruning old points
+type RetentionReference int
+
+const (
+	//RetentionWallClock points older than TimeseriesSpan before the current clock time are pruned
+	RetentionWallClock RetentionReference = iota
+	//RetentionLastSample points older than TimeseriesSpan before the last point are pruned.
+	//Useful for backfilling historical data, which would be pruned right away with RetentionWallClock
+	RetentionLastSample
+)
+
+//RetentionPolicy controls when and how older points are removed from a Timeseries
+//The zero value prunes points relative to the wall clock on every addition, without a max number of points
+type RetentionPolicy struct {
+	Reference RetentionReference
+	//MaxPoints max number of points kept. Oldest points are removed first. 0 means no limit
+	MaxPoints int
+	//PruneEvery prune old points once every 'PruneEvery' additions instead of on every addition.
+	//0 prunes on every addition. -1 never prunes on additions (use Prune() or StartPruning(..))
+	PruneEvery int
+}
+
+//SetRetentionPolicy changes how older points are pruned from this timeseries
+func (t *Timeseries) SetRetentionPolicy(policy RetentionPolicy) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.retentionPolicy = policy
+	t.additions = 0
+	t.limitPoints()
+}
+
+//Prune removes points older than TimeseriesSpan according to the retention policy
+func (t *Timeseries) Prune() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.prune()
+}
+
+//StartPruning launches a worker that prunes this timeseries every 'interval' until 'ctx' is done
+//Normally used with RetentionPolicy.PruneEvery -1 so that additions never wait for pruning
+func (t *Timeseries) StartPruning(ctx context.Context, interval time.Duration) *Worker {
+	return StartWorkerWithClock(ctx, "timeseries-prune", func() error {
+		t.Prune()
+		return nil
+	}, 0, float64(time.Second)/float64(interval), false, t.clock)
+}
+
+//afterAdd enforces the retention policy after a point is added
+func (t *Timeseries) afterAdd() {
+	t.limitPoints()
+	if t.retentionPolicy.PruneEvery < 0 {
+		return
+	}
+	t.additions = t.additions + 1
+	if t.additions >= t.retentionPolicy.PruneEvery {
+		t.additions = 0
+		t.prune()
+	}
+}
+
+func (t *Timeseries) limitPoints() {
+	max := t.retentionPolicy.MaxPoints
+	if max > 0 && t.size() > max {
+		t.dropFirst(t.size() - max)
+		t.truncateWAL()
+	}
+}
+
+//prune removes points before the retention limit, keeping the last point before it so
+//that values at the limit can still be interpolated
+func (t *Timeseries) prune() {
+	ref := t.clock.Now()
+	if t.retentionPolicy.Reference == RetentionLastSample {
+		l, ok := t.last()
+		if !ok {
+			return
+		}
+		ref = l.Time
+	}
+	t.pruneAt(ref)
+}
+
+//pruneAt same as prune(), but with TimeseriesSpan counted back from 'ref'
+//When all points are before the limit (the series stopped receiving data), only the newest one is kept
+func (t *Timeseries) pruneAt(ref time.Time) {
+	limit := ref.Add(-t.TimeseriesSpan - 1*time.Second)
+	i := t.searchFrom(limit)
+	drop := i - 2
+	if i == t.size() {
+		drop = i - 1
+	} else if t.at(i).Time.Equal(limit) {
+		drop = i - 1
+	}
+	if drop > 0 {
+		t.dropFirst(drop)
+		t.truncateWAL()
+	}
+}
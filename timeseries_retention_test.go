@@ -0,0 +1,85 @@
+package signalutils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRetentionTestTS(now time.Time, policy RetentionPolicy) (Timeseries, time.Time, *ManualClock) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(now)
+	ts := NewTimeseriesWithClock(5*time.Second, c)
+	ts.SetRetentionPolicy(policy)
+	for i := 0; i <= 10; i++ {
+		ts.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	return ts, start, c
+}
+
+func TestTSRetentionReference(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ts, _, _ := newRetentionTestTS(start.Add(12*time.Second), RetentionPolicy{})
+	assert.Equal(t, []float64{5, 6, 7, 8, 9, 10}, tsValues(ts))
+
+	//backfilled data is kept relative to the last sample
+	ts, _, _ = newRetentionTestTS(start.Add(12*time.Second), RetentionPolicy{Reference: RetentionLastSample})
+	assert.Equal(t, []float64{3, 4, 5, 6, 7, 8, 9, 10}, tsValues(ts))
+	ts, _, _ = newRetentionTestTS(start.Add(1*time.Hour), RetentionPolicy{Reference: RetentionLastSample})
+	assert.Equal(t, []float64{3, 4, 5, 6, 7, 8, 9, 10}, tsValues(ts))
+}
+
+func TestTSRetentionMaxPoints(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, _, _ := newRetentionTestTS(start, RetentionPolicy{MaxPoints: 3})
+	assert.Equal(t, []float64{8, 9, 10}, tsValues(ts))
+
+	ts.SetRetentionPolicy(RetentionPolicy{MaxPoints: 2})
+	assert.Equal(t, []float64{9, 10}, tsValues(ts))
+}
+
+func TestTSRetentionPruneEvery(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, _, _ := newRetentionTestTS(start, RetentionPolicy{Reference: RetentionLastSample, PruneEvery: 5})
+	//pruned only after the 5th and 10th additions
+	assert.Equal(t, []float64{2, 3, 4, 5, 6, 7, 8, 9, 10}, tsValues(ts))
+	ts.Prune()
+	assert.Equal(t, []float64{3, 4, 5, 6, 7, 8, 9, 10}, tsValues(ts))
+}
+
+func TestTSRetentionBackground(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, _, c := newRetentionTestTS(start, RetentionPolicy{Reference: RetentionLastSample, PruneEvery: -1})
+	assert.Equal(t, 11, ts.Size())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts.StartPruning(ctx, 1*time.Second)
+	c.Advance(1 * time.Second)
+	assert.Eventually(t, func() bool {
+		return ts.Size() == 8
+	}, 1*time.Second, 10*time.Millisecond)
+}
+
+func TestTSRetentionIdleSeries(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, _, c := newRetentionTestTS(start.Add(10*time.Second), RetentionPolicy{PruneEvery: -1})
+	assert.Equal(t, 11, ts.Size())
+
+	//no more points are added, but the older ones must still go away as the clock moves on
+	c.Advance(1 * time.Hour)
+	ts.Prune()
+	assert.Equal(t, []float64{10}, tsValues(ts))
+
+	ts, _, c = newRetentionTestTS(start.Add(10*time.Second), RetentionPolicy{PruneEvery: -1})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts.StartPruning(ctx, 1*time.Second)
+	c.Advance(1 * time.Hour)
+	assert.Eventually(t, func() bool {
+		return ts.Size() == 1
+	}, 1*time.Second, 10*time.Millisecond)
+}
@@ -163,7 +163,8 @@ func TestTSStdDev1(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	ts.Add(10)
 
-	stddev, mean := ts.StdDev(time.Now().Add(-10*time.Second), time.Now())
+	stddev, mean, ok := ts.StdDev(time.Now().Add(-10*time.Second), time.Now())
+	assert.True(t, ok)
 	assert.Equal(t, stddev, 0.0)
 	assert.Equal(t, mean, 10.0)
 }
@@ -181,7 +182,8 @@ func TestTSStdDev2(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 	ts.Add(10)
 
-	stddev, mean := ts.StdDev(time.Now().Add(-10*time.Second), time.Now())
+	stddev, mean, ok := ts.StdDev(time.Now().Add(-10*time.Second), time.Now())
+	assert.True(t, ok)
 	assert.InDeltaf(t, 3.535, stddev, 0.01, "")
 	assert.Equal(t, mean, 10.0)
 }
@@ -200,7 +202,8 @@ func TestTSLinearRegression1(t *testing.T) {
 	ts.Add(10)
 	time.Sleep(10 * time.Millisecond)
 
-	a, b, r := ts.LinearRegression(time.Now().Add(-10*time.Second), time.Now())
+	a, b, r, ok := ts.LinearRegression(time.Now().Add(-10*time.Second), time.Now())
+	assert.True(t, ok)
 	assert.InDeltaf(t, 1.4e+11, a, 20e+10, "")
 	yy := a + b*float64(time.Now().UnixNano())
 	assert.InDeltaf(t, 7.0, yy, 0.5, "")
@@ -223,10 +226,166 @@ func TestTSLinearRegression2(t *testing.T) {
 	ts.Add(-11)
 	time.Sleep(10 * time.Millisecond)
 
-	a, b, r := ts.LinearRegression(time.Now().Add(-10*time.Second), time.Now())
+	a, b, r, ok := ts.LinearRegression(time.Now().Add(-10*time.Second), time.Now())
+	assert.True(t, ok)
 	assert.InDeltaf(t, 3.0e+11, a, 2.0e+11, "")
 	assert.InDeltaf(t, -1.8e-7, b, 20.0e-8, "")
 	yy := a + b*float64(time.Now().UnixNano())
 	assert.InDeltaf(t, -13.0, yy, 1.0, "")
 	assert.InDeltaf(t, 1.0, r, 0.1, "")
 }
+
+func TestTSGetInterpolatedManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesWithClock(1000*time.Millisecond, c)
+
+	ts.Add(-100)
+	c.Advance(500 * time.Millisecond)
+	ts.Add(-1000)
+
+	nv, ok := ts.Get(c.Now().Add(-250 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, float64(-550), nv.Value)
+}
+
+func TestTSMaxSizeManualClock(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesWithClock(500*time.Millisecond, c)
+
+	for i := 0; i < 5; i++ {
+		ts.Add(1)
+		c.Advance(1 * time.Millisecond)
+	}
+	c.Advance(2 * time.Second)
+	assert.Equal(t, 5, ts.Size())
+
+	ts.Add(1)
+	assert.Equal(t, 3, ts.Size())
+}
+
+func TestTSPosBinarySearch(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1000*time.Hour, c)
+	for i := 0; i < 100000; i++ {
+		ts.Add(float64(i))
+		c.Advance(1 * time.Second)
+	}
+
+	i1, i2, ok := ts.Pos(start.Add(500 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 500, i1)
+	assert.Equal(t, 500, i2)
+
+	i1, i2, ok = ts.Pos(start.Add(500500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 500, i1)
+	assert.Equal(t, 501, i2)
+
+	_, _, ok = ts.Pos(start.Add(-1 * time.Second))
+	assert.False(t, ok)
+	_, _, ok = ts.Pos(start.Add(100000 * time.Second))
+	assert.False(t, ok)
+
+	v, ok := ts.Get(start.Add(99998500 * time.Millisecond))
+	assert.True(t, ok)
+	assert.Equal(t, 99998.5, v.Value)
+
+	avg, ok := ts.Avg(start.Add(10*time.Second), start.Add(12*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 11.0, avg)
+
+	tvs, vs := ts.ValuesRange(start.Add(10*time.Second), start.Add(12500*time.Millisecond))
+	assert.Equal(t, []float64{10, 11, 12}, vs)
+	assert.Equal(t, start.Add(10*time.Second), tvs[0].Time)
+
+	_, vs = ts.ValuesRange(start.Add(12*time.Second), start.Add(10*time.Second))
+	assert.Equal(t, 0, len(vs))
+}
+
+func BenchmarkTSGet(b *testing.B) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ts := NewTimeseriesWithClock(1000*time.Hour, c)
+	for i := 0; i < 100000; i++ {
+		ts.Add(float64(i))
+		c.Advance(1 * time.Second)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ts.Get(c.Now().Add(-time.Duration(i%100000) * time.Second))
+	}
+}
+
+func TestTSRangeStats(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	for _, v := range []float64{1000, 10, 15, 10, 5, 10, 1000} {
+		ts.Add(v)
+		c.Advance(1 * time.Second)
+	}
+
+	//only points between 1s and 5s are considered
+	stddev, mean, ok := ts.StdDev(start.Add(1*time.Second), start.Add(5*time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, mean)
+	assert.InDelta(t, 3.535, stddev, 0.01)
+
+	a, b, r, ok := ts.LinearRegression(start.Add(1*time.Second), start.Add(3*time.Second))
+	assert.True(t, ok)
+	assert.InDelta(t, 35.0/3, a+b*float64(start.Add(2*time.Second).UnixNano()), 1e-6)
+	assert.InDelta(t, 0.0, b, 1e-12)
+	assert.InDelta(t, 0.0, r, 1e-9)
+
+	a, b, r, ok = ts.LinearRegression(start.Add(1*time.Second), start.Add(2*time.Second))
+	assert.True(t, ok)
+	assert.InDelta(t, 5e-9, b, 1e-15)
+	assert.InDelta(t, 1.0, r, 1e-9)
+
+	ts2 := NewTimeseriesWithClock(1*time.Hour, c)
+	ts2.Add(7)
+	c.Advance(1 * time.Second)
+	ts2.Add(7)
+	_, _, r, ok = ts2.LinearRegression(start, c.Now())
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, r)
+
+	//empty and single point ranges
+	_, _, ok = ts.StdDev(start.Add(1500*time.Millisecond), start.Add(1700*time.Millisecond))
+	assert.False(t, ok)
+	_, _, ok = ts.StdDev(start.Add(1*time.Second), start.Add(1500*time.Millisecond))
+	assert.False(t, ok)
+	_, _, _, ok = ts.LinearRegression(start.Add(-10*time.Second), start.Add(-5*time.Second))
+	assert.False(t, ok)
+	_, ok = ts.Avg(start.Add(100*time.Second), start.Add(200*time.Second))
+	assert.False(t, ok)
+}
+
+func TestTSValuesRangeInterpolated(t *testing.T) {
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	ts := NewTimeseriesWithClock(1*time.Hour, c)
+	for _, v := range []float64{0, 10, 20, 30} {
+		ts.Add(v)
+		c.Advance(1 * time.Second)
+	}
+
+	_, vs := ts.ValuesRange(start.Add(500*time.Millisecond), start.Add(2500*time.Millisecond))
+	assert.Equal(t, []float64{10, 20}, vs)
+
+	tvs, vs := ts.ValuesRangeInterpolated(start.Add(500*time.Millisecond), start.Add(2500*time.Millisecond))
+	assert.Equal(t, []float64{5, 10, 20, 25}, vs)
+	assert.Equal(t, start.Add(500*time.Millisecond), tvs[0].Time)
+	assert.Equal(t, start.Add(2500*time.Millisecond), tvs[3].Time)
+
+	//no duplicated points on exact boundaries and no extrapolation
+	_, vs = ts.ValuesRangeInterpolated(start.Add(-1*time.Second), start.Add(2*time.Second))
+	assert.Equal(t, []float64{0, 10, 20}, vs)
+
+	//range between two points
+	_, vs = ts.ValuesRangeInterpolated(start.Add(1200*time.Millisecond), start.Add(1700*time.Millisecond))
+	assert.InDeltaSlice(t, []float64{12, 17}, vs, 1e-9)
+
+	_, vs = ts.ValuesRangeInterpolated(start.Add(10*time.Second), start.Add(20*time.Second))
+	assert.Equal(t, 0, len(vs))
+}
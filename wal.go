@@ -0,0 +1,362 @@
+package signalutils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+//walRecordSize time (8 bytes), value (8 bytes) and CRC32 of both (4 bytes)
+const walRecordSize = 20
+
+var walCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+//errWALCorrupted a record is incomplete or its checksum doesn't match
+var errWALCorrupted = errors.New("corrupted record")
+
+type walSegment struct {
+	index int
+	//maxTimeUnixNano time of the newest point in segment
+	maxTimeUnixNano int64
+}
+
+//WAL append only write ahead log of timeseries points, stored as fixed size checksummed records
+//in segment files inside a directory. A new segment is started when the current one reaches 'segmentSize' bytes,
+//so that old points can be discarded by removing whole segments (see Truncate(..))
+//Records are written to the OS right away, so they survive process crashes. Use Sync() to survive power failures too
+//Only initialize this with OpenWAL(..)
+type WAL struct {
+	dir         string
+	segmentSize int64
+	segments    []walSegment
+	file        *os.File
+	fileBytes   int64
+	failed      error
+	m           *sync.Mutex
+}
+
+//OpenWAL opens (or creates) a WAL in directory 'dir', verifying the checksums of all records
+//A partially written or corrupted record at the end of the last segment (left by a crash) is discarded.
+//Corrupted records in other segments and errors reading any segment are returned
+func OpenWAL(dir string, segmentSize int64) (*WAL, error) {
+	if segmentSize < walRecordSize {
+		return nil, fmt.Errorf("segmentSize must be at least %d bytes", walRecordSize)
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		dir:         dir,
+		segmentSize: segmentSize,
+		segments:    make([]walSegment, 0),
+		m:           &sync.Mutex{},
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".wal") {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSuffix(f.Name(), ".wal"))
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, walSegment{index: index, maxTimeUnixNano: math.MinInt64})
+	}
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].index < w.segments[j].index
+	})
+
+	for i := range w.segments {
+		s := &w.segments[i]
+		valid, err := w.readSegment(s.index, func(tv TimeValue) {
+			if tv.Time.UnixNano() > s.maxTimeUnixNano {
+				s.maxTimeUnixNano = tv.Time.UnixNano()
+			}
+		})
+		if err == nil {
+			continue
+		}
+		if i < len(w.segments)-1 || !errors.Is(err, errWALCorrupted) {
+			return nil, err
+		}
+		//torn write at the tail
+		err = os.Truncate(w.segmentPath(s.index), valid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(w.segments) == 0 {
+		w.segments = append(w.segments, walSegment{index: 0, maxTimeUnixNano: math.MinInt64})
+	}
+	err = w.openSegment(w.segments[len(w.segments)-1].index)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+//Append records a point at the end of the log
+//If a record is partially written, it is removed so that later records can still be replayed. When that fails too,
+//all further appends return an error, as they would be lost on the next OpenWAL(..)
+func (w *WAL) Append(tv TimeValue) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("WAL is closed")
+	}
+	if w.failed != nil {
+		return fmt.Errorf("WAL failed: %w", w.failed)
+	}
+	if w.fileBytes+walRecordSize > w.segmentSize {
+		err := w.file.Close()
+		if err != nil {
+			return err
+		}
+		index := w.segments[len(w.segments)-1].index + 1
+		w.segments = append(w.segments, walSegment{index: index, maxTimeUnixNano: math.MinInt64})
+		err = w.openSegment(index)
+		if err != nil {
+			return err
+		}
+	}
+	record := make([]byte, walRecordSize)
+	binary.LittleEndian.PutUint64(record[0:], uint64(tv.Time.UnixNano()))
+	binary.LittleEndian.PutUint64(record[8:], math.Float64bits(tv.Value))
+	binary.LittleEndian.PutUint32(record[16:], crc32.Checksum(record[:16], walCRCTable))
+	n, err := w.file.Write(record)
+	if err != nil {
+		if n > 0 {
+			terr := w.file.Truncate(w.fileBytes)
+			if terr != nil {
+				w.failed = fmt.Errorf("partially written record at offset %d: %w", w.fileBytes, terr)
+			}
+		}
+		return err
+	}
+	w.fileBytes = w.fileBytes + int64(n)
+	s := &w.segments[len(w.segments)-1]
+	if tv.Time.UnixNano() > s.maxTimeUnixNano {
+		s.maxTimeUnixNano = tv.Time.UnixNano()
+	}
+	return nil
+}
+
+//Replay calls 'f' for each point in the log, from the oldest to the newest record
+func (w *WAL) Replay(f func(TimeValue)) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	for _, s := range w.segments {
+		_, err := w.readSegment(s.index, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Truncate removes segments with points older than 'before' only. The current segment is never removed
+func (w *WAL) Truncate(before time.Time) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	b := before.UnixNano()
+	for len(w.segments) > 1 && w.segments[0].maxTimeUnixNano < b {
+		err := os.Remove(w.segmentPath(w.segments[0].index))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+//Reset removes all records from the log
+func (w *WAL) Reset() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("WAL is closed")
+	}
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+	for _, s := range w.segments {
+		err := os.Remove(w.segmentPath(s.index))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	index := w.segments[len(w.segments)-1].index + 1
+	w.segments = []walSegment{{index: index, maxTimeUnixNano: math.MinInt64}}
+	w.failed = nil
+	return w.openSegment(index)
+}
+
+//Sync commits written records to stable storage
+func (w *WAL) Sync() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.file == nil {
+		return fmt.Errorf("WAL is closed")
+	}
+	return w.file.Sync()
+}
+
+//Close syncs and closes the current segment. The WAL can't be used anymore
+func (w *WAL) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if err != nil {
+		return err
+	}
+	err = w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *WAL) segmentPath(index int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d.wal", index))
+}
+
+func (w *WAL) openSegment(index int) error {
+	f, err := os.OpenFile(w.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.fileBytes = info.Size()
+	return nil
+}
+
+//readSegment calls 'f' for each record of a segment. Returns the size of the valid records and
+//an error if a record is incomplete or its checksum doesn't match
+func (w *WAL) readSegment(index int, f func(TimeValue)) (int64, error) {
+	data, err := ioutil.ReadFile(w.segmentPath(index))
+	if err != nil {
+		return 0, err
+	}
+	valid := int64(0)
+	for len(data) > 0 {
+		if len(data) < walRecordSize {
+			return valid, fmt.Errorf("segment %d: %w at offset %d: %s", index, errWALCorrupted, valid, io.ErrUnexpectedEOF)
+		}
+		record := data[:walRecordSize]
+		if crc32.Checksum(record[:16], walCRCTable) != binary.LittleEndian.Uint32(record[16:]) {
+			return valid, fmt.Errorf("segment %d: %w at offset %d: checksum mismatch", index, errWALCorrupted, valid)
+		}
+		t := int64(binary.LittleEndian.Uint64(record[0:]))
+		v := math.Float64frombits(binary.LittleEndian.Uint64(record[8:]))
+		f(TimeValue{time.Unix(0, t), v})
+		valid = valid + walRecordSize
+		data = data[walRecordSize:]
+	}
+	return valid, nil
+}
+
+//AttachWAL replays the points recorded in 'wal' into this timeseries and then records in 'wal' every point added
+//to this timeseries, so that they are not lost if the process restarts. WAL segments are removed as their points
+//are pruned by the retention policy. Set the insert and retention policies before attaching, so that points are replayed
+//the same way they were added. When also saving snapshots, take them with Checkpoint() instead of MarshalBinary(),
+//so that only points added after the snapshot are replayed when it is restored
+func (t *Timeseries) AttachWAL(wal *WAL) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	err := wal.Replay(func(tv TimeValue) {
+		t.addWithTime(tv.Value, tv.Time, nil)
+	})
+	if err != nil {
+		return err
+	}
+	t.wal = wal
+	t.truncateWAL()
+	return nil
+}
+
+//Checkpoint encodes this timeseries like MarshalBinary() and resets the attached WAL, without letting points
+//be added in between. Restore the snapshot with UnmarshalBinary(..) before attaching the WAL again, so that
+//each point is either in the snapshot or replayed from the WAL, but never in both.
+//If the WAL can't be reset, the snapshot is returned along with the error, and must not be used with this WAL
+func (t *Timeseries) Checkpoint() ([]byte, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.checkpoint(func() interface{} {
+		return t.currentState()
+	})
+}
+
+//checkpoint encodes the state returned by 'state' and resets the WAL. The caller must hold the write lock
+func (t *Timeseries) checkpoint(state func() interface{}) ([]byte, error) {
+	data, err := marshalBinaryState(state())
+	if err != nil {
+		return nil, err
+	}
+	if t.wal != nil {
+		err = t.wal.Reset()
+	}
+	return data, err
+}
+
+//truncateWAL removes WAL segments with points older than the oldest point in this timeseries only
+func (t *Timeseries) truncateWAL() {
+	if t.wal == nil || t.size() == 0 {
+		return
+	}
+	err := t.wal.Truncate(t.at(0).Time)
+	if err != nil {
+		logrus.Warnf("timeseries: could not truncate WAL. err=%s", err)
+	}
+}
+
+//AttachWAL replays the counter values recorded in 'wal' and records every new counter value in it
+//See Timeseries.AttachWAL(..)
+func (t *TimeseriesCounterRate) AttachWAL(wal *WAL) error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	err := t.Timeseries.AttachWAL(wal)
+	if err != nil {
+		return err
+	}
+	if l, ok := t.Timeseries.Last(); ok {
+		t.ccounter = l.Value
+	}
+	return nil
+}
+
+//Checkpoint encodes the counter timeseries like MarshalBinary() and resets the attached WAL, without letting
+//the counter change in between. See Timeseries.Checkpoint()
+func (t *TimeseriesCounterRate) Checkpoint() ([]byte, error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.Timeseries.m.Lock()
+	defer t.Timeseries.m.Unlock()
+	return t.Timeseries.checkpoint(func() interface{} {
+		return t.currentState(t.Timeseries.currentState())
+	})
+}
@@ -0,0 +1,290 @@
+package signalutils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func walTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "wal")
+	assert.Nil(t, err)
+	return dir
+}
+
+func walSegmentFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+	assert.Nil(t, err)
+	return files
+}
+
+func walReplay(t *testing.T, w *WAL) []float64 {
+	vs := make([]float64, 0)
+	assert.Nil(t, w.Replay(func(tv TimeValue) {
+		vs = append(vs, tv.Value)
+	}))
+	return vs
+}
+
+func TestWALAppendReplay(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	//5 records per segment
+	w, err := OpenWAL(dir, 100)
+	assert.Nil(t, err)
+	expected := make([]float64, 0)
+	for i := 0; i < 12; i++ {
+		assert.Nil(t, w.Append(TimeValue{start.Add(time.Duration(i) * time.Second), float64(i)}))
+		expected = append(expected, float64(i))
+	}
+	assert.Equal(t, 3, len(walSegmentFiles(t, dir)))
+	assert.Equal(t, expected, walReplay(t, w))
+	assert.Nil(t, w.Close())
+	assert.NotNil(t, w.Append(TimeValue{start, 1}))
+
+	w, err = OpenWAL(dir, 100)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Append(TimeValue{start.Add(12 * time.Second), 12}))
+	expected = append(expected, 12)
+	var last TimeValue
+	assert.Nil(t, w.Replay(func(tv TimeValue) {
+		last = tv
+	}))
+	assert.True(t, start.Add(12*time.Second).Equal(last.Time))
+	assert.Equal(t, expected, walReplay(t, w))
+
+	//removes segments with older points only
+	assert.Nil(t, w.Truncate(start.Add(5*time.Second)))
+	assert.Equal(t, 2, len(walSegmentFiles(t, dir)))
+	assert.Equal(t, expected[5:], walReplay(t, w))
+	assert.Nil(t, w.Truncate(start.Add(1*time.Hour)))
+	assert.Equal(t, expected[10:], walReplay(t, w))
+
+	assert.Nil(t, w.Reset())
+	assert.Equal(t, []float64{}, walReplay(t, w))
+	assert.Nil(t, w.Append(TimeValue{start, 1}))
+	assert.Equal(t, []float64{1}, walReplay(t, w))
+	assert.Nil(t, w.Close())
+
+	_, err = OpenWAL(dir, 10)
+	assert.NotNil(t, err)
+}
+
+func TestWALCorruption(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w, _ := OpenWAL(dir, 100)
+	for i := 0; i < 7; i++ {
+		w.Append(TimeValue{start.Add(time.Duration(i) * time.Second), float64(i)})
+	}
+	w.Close()
+
+	//torn write at the tail is discarded
+	files := walSegmentFiles(t, dir)
+	f, _ := os.OpenFile(files[1], os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+	w, err := OpenWAL(dir, 100)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Append(TimeValue{start.Add(7 * time.Second), 7}))
+	assert.Equal(t, []float64{0, 1, 2, 3, 4, 5, 6, 7}, walReplay(t, w))
+	w.Close()
+
+	//corrupted records in older segments are errors
+	data, _ := ioutil.ReadFile(files[0])
+	data[25] = data[25] ^ 0xff
+	ioutil.WriteFile(files[0], data, 0644)
+	_, err = OpenWAL(dir, 100)
+	assert.NotNil(t, err)
+
+	//segments that can't be read are errors, and are not truncated
+	dir2 := walTestDir(t)
+	defer os.RemoveAll(dir2)
+	assert.Nil(t, os.Mkdir(filepath.Join(dir2, "00000001.wal"), 0755))
+	ioutil.WriteFile(filepath.Join(dir2, "00000001.wal", "x"), []byte{1}, 0644)
+	_, err = OpenWAL(dir2, 100)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(dir2, "00000001.wal", "x"))
+	assert.Nil(t, err)
+}
+
+func TestWALAppendError(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	w, _ := OpenWAL(dir, 100)
+	assert.Nil(t, w.Append(TimeValue{start, 0}))
+
+	//failed writes don't leave gaps before the next records
+	f := w.file
+	w.file, _ = os.Open(f.Name())
+	assert.NotNil(t, w.Append(TimeValue{start.Add(1 * time.Second), 1}))
+	w.file.Close()
+	w.file = f
+	assert.Nil(t, w.Append(TimeValue{start.Add(2 * time.Second), 2}))
+	w.Close()
+	w, err := OpenWAL(dir, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{0, 2}, walReplay(t, w))
+
+	//appends are rejected after a partially written record couldn't be removed
+	w.failed = fmt.Errorf("test")
+	assert.NotNil(t, w.Append(TimeValue{start.Add(3 * time.Second), 3}))
+	assert.Nil(t, w.Reset())
+	assert.Nil(t, w.Append(TimeValue{start.Add(3 * time.Second), 3}))
+	assert.Equal(t, []float64{3}, walReplay(t, w))
+	w.Close()
+}
+
+func TestTimeseriesWAL(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	newTS := func() (Timeseries, *WAL) {
+		ts := NewTimeseriesWithClock(5*time.Second, c)
+		ts.SetInsertPolicy(InsertPolicy{Duplicate: DuplicateSum})
+		ts.SetRetentionPolicy(RetentionPolicy{Reference: RetentionLastSample})
+		w, err := OpenWAL(dir, 100)
+		assert.Nil(t, err)
+		assert.Nil(t, ts.AttachWAL(w))
+		return ts, w
+	}
+
+	ts, w := newTS()
+	for i := 0; i < 8; i++ {
+		ts.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Nil(t, ts.AddWithTime(10, start.Add(7*time.Second)))
+	//dropped points are not recorded
+	assert.NotNil(t, ts.AddWithTime(1, start))
+	assert.Equal(t, []float64{0, 1, 2, 3, 4, 5, 6, 17}, tsValues(ts))
+	w.Close()
+
+	ts, w = newTS()
+	assert.Equal(t, []float64{0, 1, 2, 3, 4, 5, 6, 17}, tsValues(ts))
+	assert.Equal(t, int64(0), ts.Dropped())
+
+	//segments are removed with retention
+	for i := 8; i < 30; i++ {
+		ts.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	assert.Equal(t, 3, len(walSegmentFiles(t, dir)))
+	w.Close()
+	ts, w = newTS()
+	assert.Equal(t, []float64{22, 23, 24, 25, 26, 27, 28, 29}, tsValues(ts))
+
+	ts.Reset()
+	w.Close()
+	ts, w = newTS()
+	assert.Equal(t, 0, ts.Size())
+	w.Close()
+}
+
+func TestTimeseriesCounterRateWAL(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	w, _ := OpenWAL(dir, 1000)
+	tc := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+	assert.Nil(t, tc.AttachWAL(w))
+	tc.Inc(10)
+	c.Advance(1 * time.Second)
+	tc.Inc(10)
+	w.Close()
+
+	w, _ = OpenWAL(dir, 1000)
+	tc = NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+	assert.Nil(t, tc.AttachWAL(w))
+	c.Advance(1 * time.Second)
+	tc.Inc(20)
+	r, ok := tc.Rate(2 * time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 15.0, r)
+	w.Close()
+}
+
+func TestTimeseriesWALUnmarshal(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	other := NewTimeseriesWithClock(5*time.Second, c)
+	other.Add(100)
+	b, _ := other.MarshalBinary()
+
+	ts := NewTimeseriesWithClock(5*time.Second, c)
+	w, _ := OpenWAL(dir, 100)
+	defer w.Close()
+	assert.Nil(t, ts.AttachWAL(w))
+	ts.Add(1)
+
+	//restoring would disconnect the WAL from the timeseries
+	assert.NotNil(t, ts.UnmarshalBinary(b))
+	assert.Equal(t, []float64{1}, tsValues(ts))
+	c.Advance(1 * time.Second)
+	ts.Add(2)
+	count := 0
+	w.Replay(func(tv TimeValue) {
+		count++
+	})
+	assert.Equal(t, 2, count)
+}
+
+func TestTimeseriesWALCheckpoint(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := c.Now()
+	newTS := func() Timeseries {
+		ts := NewTimeseriesWithClock(1*time.Minute, c)
+		ts.SetInsertPolicy(InsertPolicy{Duplicate: DuplicateSum})
+		return ts
+	}
+
+	ts := newTS()
+	w, _ := OpenWAL(dir, 100)
+	assert.Nil(t, ts.AttachWAL(w))
+	ts.AddWithTime(1, start)
+	ts.AddWithTime(2, start.Add(1*time.Second))
+	b, err := ts.Checkpoint()
+	assert.Nil(t, err)
+	//duplicates added after the snapshot are summed only once
+	ts.AddWithTime(10, start.Add(1*time.Second))
+	ts.AddWithTime(3, start.Add(2*time.Second))
+	w.Close()
+
+	restored := newTS()
+	assert.Nil(t, restored.UnmarshalBinary(b))
+	w, _ = OpenWAL(dir, 100)
+	assert.Nil(t, restored.AttachWAL(w))
+	assert.Equal(t, []float64{1, 12, 3}, tsValues(restored))
+	w.Close()
+
+	//counters
+	dir2 := walTestDir(t)
+	defer os.RemoveAll(dir2)
+	w, _ = OpenWAL(dir2, 100)
+	tc := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+	assert.Nil(t, tc.AttachWAL(w))
+	tc.Inc(10)
+	b, err = tc.Checkpoint()
+	assert.Nil(t, err)
+	c.Advance(1 * time.Second)
+	tc.Inc(5)
+	w.Close()
+
+	rc := NewTimeseriesCounterRateWithClock(1*time.Minute, c)
+	assert.Nil(t, rc.UnmarshalBinary(b))
+	w, _ = OpenWAL(dir2, 100)
+	assert.Nil(t, rc.AttachWAL(w))
+	assert.Equal(t, []float64{10, 15}, tsValues(rc.Timeseries))
+	w.Close()
+}
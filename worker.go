@@ -2,6 +2,7 @@ package signalutils
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -13,11 +14,13 @@ import (
 type Worker struct {
 	minFreq         float64
 	maxFreq         float64
-	ticker          *time.Ticker
+	ticker          Ticker
 	step            StepFunc
 	stopOnErr       bool
 	name            string
 	active          bool
+	clock           Clock
+	m               *sync.RWMutex
 	CurrentFreq     float64
 	CurrentStepTime time.Duration
 }
@@ -30,40 +33,64 @@ type StepFunc func() error
 //if the function is being run in a frequency less than minFreq, a logrus.Debug log will show this
 //this situation happens when the function is too slow
 func StartWorker(ctx context.Context, name string, step StepFunc, minFreq float64, maxFreq float64, stopOnErr bool) *Worker {
+	return StartWorkerWithClock(ctx, name, step, minFreq, maxFreq, stopOnErr, SystemClock)
+}
+
+//StartWorkerWithClock same as StartWorker(..), but using 'clock' for limiting and measuring the loop frequency
+func StartWorkerWithClock(ctx context.Context, name string, step StepFunc, minFreq float64, maxFreq float64, stopOnErr bool, clock Clock) *Worker {
 	c := &Worker{
 		name:      name,
 		minFreq:   minFreq,
 		maxFreq:   maxFreq,
-		ticker:    time.NewTicker(time.Duration((float64(time.Second) / maxFreq))),
+		ticker:    clock.NewTicker(time.Duration((float64(time.Second) / maxFreq))),
 		step:      step,
 		stopOnErr: stopOnErr,
 		active:    false,
+		clock:     clock,
+		m:         &sync.RWMutex{},
 	}
 	logrus.Tracef("%s: starting goroutine", name)
 	go c.run(ctx)
 	return c
 }
 
+//Active whether the worker is still looping (it stops when the context is done or on step errors if stopOnErr)
+func (c *Worker) Active() bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.active
+}
+
+func (c *Worker) setActive(active bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.active = active
+}
+
 func (c *Worker) run(ctx context.Context) {
-	c.active = true
+	c.setActive(true)
+	defer c.ticker.Stop()
+	loopStart := c.clock.Now()
 	for {
-		loopStart := time.Now()
 		select {
 		case <-ctx.Done():
-			c.active = false
+			c.setActive(false)
 			logrus.Tracef("%s: deactivated by Context", c.name)
 			return
-		case <-c.ticker.C:
-			stepStart := time.Now()
+		case <-c.ticker.C():
+			stepStart := c.clock.Now()
 			err := c.step()
-			c.CurrentStepTime = time.Since(stepStart)
-			loopElapsed := time.Since(loopStart)
-			c.CurrentFreq = float64(1) / loopElapsed.Seconds()
+			stepEnd := c.clock.Now()
+			c.m.Lock()
+			c.CurrentStepTime = stepEnd.Sub(stepStart)
+			c.CurrentFreq = float64(1) / stepEnd.Sub(loopStart).Seconds()
+			c.m.Unlock()
+			loopStart = stepEnd
 			logrus.Tracef("%s: STEP time=%d ms; loop freq=%.2f", c.name, c.CurrentStepTime.Milliseconds(), c.CurrentFreq)
 			if err != nil {
 				logrus.Debugf("%s: STEP err=%s", c.name, err)
 				if c.stopOnErr {
-					c.active = false
+					c.setActive(false)
 					return
 				}
 			}
@@ -9,34 +9,84 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+//workerStats current loop frequency and step time of 'w', read under its lock
+func workerStats(w *Worker) (float64, time.Duration) {
+	w.m.RLock()
+	defer w.m.RUnlock()
+	return w.CurrentFreq, w.CurrentStepTime
+}
+
 func TestWorkerStepError(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	// logrus.SetLevel(logrus.TraceLevel)
-	w := StartWorker(ctx, "test1", func() error {
-		time.Sleep(200 * time.Millisecond)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	steps := make(chan bool)
+	w := StartWorkerWithClock(ctx, "test1", func() error {
+		//wait for the test to check the worker while the step is running
+		steps <- true
+		<-steps
 		return fmt.Errorf("Error here")
-	}, 3, 5, true)
-	time.Sleep(100 * time.Millisecond)
-	assert.True(t, w.active)
-	time.Sleep(400 * time.Millisecond)
-	assert.False(t, w.active)
+	}, 3, 5, true, c)
+	c.Advance(200 * time.Millisecond)
+	<-steps
+	assert.True(t, w.Active())
+	steps <- true
+	assert.Eventually(t, func() bool {
+		return !w.Active()
+	}, 1*time.Second, 1*time.Millisecond)
 }
 
 func TestWorkerStepFreq(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	// logrus.SetLevel(logrus.TraceLevel)
-	w := StartWorker(ctx, "test1", func() error {
-		time.Sleep(15 * time.Millisecond)
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	w := StartWorkerWithClock(ctx, "test1", func() error {
+		c.Advance(15 * time.Millisecond)
+		return nil
+	}, 3.0, 5.0, true, c)
+
+	//first loop, from worker start
+	c.Advance(200 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, st := workerStats(w)
+		return st == 15*time.Millisecond
+	}, 1*time.Second, 1*time.Millisecond)
+	assert.True(t, w.Active())
+
+	//next loops are limited by maxFreq
+	for i := 0; i < 3; i++ {
+		c.Advance(185 * time.Millisecond)
+		assert.Eventually(t, func() bool {
+			f, _ := workerStats(w)
+			return f == 5
+		}, 1*time.Second, 1*time.Millisecond)
+	}
+	f, st := workerStats(w)
+	assert.Equal(t, 5.0, f)
+	assert.Equal(t, 15*time.Millisecond, st)
+
+	cancel()
+	assert.Eventually(t, func() bool {
+		return !w.Active()
+	}, 1*time.Second, 1*time.Millisecond)
+}
+
+func TestWorkerManualClock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	steps := make(chan bool)
+	w := StartWorkerWithClock(ctx, "test1", func() error {
+		steps <- true
 		return nil
-	}, 3.0, 5.0, true)
-	time.Sleep(200 * time.Millisecond)
-	assert.True(t, w.active)
-	time.Sleep(2000 * time.Millisecond)
-	assert.InDeltaf(t, 5, w.CurrentFreq, 2, "")
-	assert.InDeltaf(t, 15, w.CurrentStepTime.Milliseconds(), 5, "")
+	}, 3.0, 5.0, true, c)
+	for i := 0; i < 3; i++ {
+		c.Advance(200 * time.Millisecond)
+		<-steps
+	}
+	assert.True(t, w.Active())
 	cancel()
-	time.Sleep(300 * time.Millisecond)
-	assert.False(t, w.active)
+	assert.Eventually(t, func() bool {
+		return !w.Active()
+	}, 1*time.Second, 1*time.Millisecond)
 }